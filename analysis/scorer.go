@@ -0,0 +1,181 @@
+package analysis
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/cdipaolo/sentiment"
+	"github.com/grassmudhorses/vader-go/lexicon"
+	"github.com/grassmudhorses/vader-go/sentitext"
+)
+
+/*
+Sentiment scorers and the registry used to look them up by name.
+Every scorer returns the same SentimentResult schema so db, api and exports don't care which model produced a score.
+*/
+
+const (
+	LabelPositive = "positive"
+	LabelNegative = "negative"
+	LabelNeutral  = "neutral"
+)
+
+type SentimentResult struct {
+	Scorer  string `json:"scorer" bson:"scorer"`
+	Version string `json:"version" bson:"version"`
+	// polarity components, each in [0, 1]
+	Positive float64 `json:"positive" bson:"positive"`
+	Negative float64 `json:"negative" bson:"negative"`
+	Neutral  float64 `json:"neutral" bson:"neutral"`
+	// Compound is the overall polarity in [-1, 1]
+	Compound   float64 `json:"compound" bson:"compound"`
+	Label      string  `json:"label" bson:"label"`
+	Confidence float64 `json:"confidence" bson:"confidence"`
+}
+
+type Scorer interface {
+	Name() string
+	Version() string
+	Score(text string) (SentimentResult, error)
+}
+
+var (
+	scorersMu sync.RWMutex
+	scorers   = map[string]Scorer{}
+)
+
+func RegisterScorer(s Scorer) {
+	scorersMu.Lock()
+	defer scorersMu.Unlock()
+	if _, exists := scorers[s.Name()]; exists {
+		panic(fmt.Sprintf("scorer %q registered twice", s.Name()))
+	}
+	scorers[s.Name()] = s
+}
+
+func GetScorer(name string) (Scorer, error) {
+	scorersMu.RLock()
+	defer scorersMu.RUnlock()
+	s, ok := scorers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown scorer %q", name)
+	}
+	return s, nil
+}
+
+// ScorerNames lists the registered scorers, sorted
+func ScorerNames() []string {
+	scorersMu.RLock()
+	defer scorersMu.RUnlock()
+	names := make([]string, 0, len(scorers))
+	for name := range scorers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	RegisterScorer(VaderScorer{})
+	RegisterScorer(&IMDBScorer{})
+}
+
+// ScoreAll scores every text with every scorer, results[i] holds the scores of texts[i] keyed by scorer name.
+// It gives up with ctx's error once ctx is done.
+func ScoreAll(ctx context.Context, texts []string, scorers []Scorer) ([]map[string]SentimentResult, error) {
+	results := make([]map[string]SentimentResult, len(texts))
+	for i, text := range texts {
+		results[i] = make(map[string]SentimentResult, len(scorers))
+		for _, scorer := range scorers {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			result, err := scorer.Score(text)
+			if err != nil {
+				return nil, fmt.Errorf("%s: could not score text %d: %w", scorer.Name(), i, err)
+			}
+			results[i][scorer.Name()] = result
+		}
+	}
+	return results, nil
+}
+
+// LabelForCompound uses the usual VADER thresholds to label a compound score
+func LabelForCompound(compound float64) string {
+	switch {
+	case compound >= 0.05:
+		return LabelPositive
+	case compound <= -0.05:
+		return LabelNegative
+	default:
+		return LabelNeutral
+	}
+}
+
+// VaderScorer scores text with the vader-go default lexicon
+type VaderScorer struct{}
+
+func (VaderScorer) Name() string    { return "vader" }
+func (VaderScorer) Version() string { return "vader-go-default-lexicon" }
+
+func (v VaderScorer) Score(text string) (SentimentResult, error) {
+	results := sentitext.PolarityScore(sentitext.Parse(text, lexicon.DefaultLexicon))
+	return SentimentResult{
+		Scorer:   v.Name(),
+		Version:  v.Version(),
+		Positive: results.Positive,
+		Negative: results.Negative,
+		Neutral:  results.Neutral,
+		Compound: results.Compound,
+		Label:    LabelForCompound(results.Compound),
+		// the lexicon has no notion of certainty, use the strength of the polarity
+		Confidence: math.Abs(results.Compound),
+	}, nil
+}
+
+// IMDBScorer scores text with the naive bayes model trained on IMDB reviews
+type IMDBScorer struct {
+	once  sync.Once
+	model sentiment.Models
+	err   error
+}
+
+func (*IMDBScorer) Name() string    { return "imdb" }
+func (*IMDBScorer) Version() string { return "cdipaolo-sentiment-imdb" }
+
+func (m *IMDBScorer) Score(text string) (SentimentResult, error) {
+	// restoring decompresses and parses the whole model, far too slow to do per call
+	m.once.Do(func() {
+		m.model, m.err = sentiment.Restore()
+	})
+	if m.err != nil {
+		return SentimentResult{}, fmt.Errorf("could not restore imdb model: %w", m.err)
+	}
+	bayes := m.model[sentiment.English]
+	class, probability := bayes.Probability(text)
+	positive, confidence := probability, probability
+	if class == 0 {
+		positive = 1 - probability
+	}
+	if math.IsNaN(probability) {
+		// the probability underflows on long texts, fall back to the class alone
+		class = bayes.Predict(text)
+		positive, confidence = float64(class), 0
+	}
+	label := LabelPositive
+	if class == 0 {
+		label = LabelNegative
+	}
+	return SentimentResult{
+		Scorer:     m.Name(),
+		Version:    m.Version(),
+		Positive:   positive,
+		Negative:   1 - positive,
+		Compound:   2*positive - 1,
+		Label:      label,
+		Confidence: confidence,
+	}, nil
+}
@@ -0,0 +1,66 @@
+package analysis
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/dghubble/go-twitter/twitter"
+)
+
+func TestScorerRegistry(t *testing.T) {
+	for _, name := range []string{"vader", "imdb"} {
+		if _, err := GetScorer(name); err != nil {
+			t.Errorf("GetScorer(%q): %v", name, err)
+		}
+	}
+	if _, err := GetScorer("nope"); err == nil {
+		t.Error("expected an error for an unknown scorer")
+	}
+}
+
+func TestScorersLabelText(t *testing.T) {
+	for _, name := range ScorerNames() {
+		scorer, _ := GetScorer(name)
+		positive, err := scorer.Score("I love this, it is wonderful and great")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if positive.Scorer != name || positive.Label != LabelPositive || positive.Compound <= 0 {
+			t.Errorf("%s: expected a positive result, got %+v", name, positive)
+		}
+		if positive.Compound < -1 || positive.Compound > 1 {
+			t.Errorf("%s: compound out of range: %v", name, positive.Compound)
+		}
+	}
+}
+
+func TestScoreTweetRejectsBadInput(t *testing.T) {
+	if _, err := ScoreTweet(VaderScorer{}, "not a tweet"); err == nil {
+		t.Fatal("expected an error for a non tweet message")
+	}
+	msg, err := ScoreTweet(VaderScorer{}, &twitter.Tweet{ID: 7, Text: "terrible, awful day"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.BaseTweet.ID != 7 || msg.Scores["vader"].Label != LabelNegative {
+		t.Errorf("unexpected message %+v", msg)
+	}
+}
+
+func TestScoreAll(t *testing.T) {
+	texts := []string{"what a great day", "what a terrible day"}
+	results, err := ScoreAll(context.Background(), texts, []Scorer{VaderScorer{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0]["vader"].Label != LabelPositive || results[1]["vader"].Label != LabelNegative {
+		t.Errorf("unexpected results %+v", results)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := ScoreAll(ctx, texts, []Scorer{VaderScorer{}}); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
@@ -1,93 +1,96 @@
 package analysis
 
 import (
-	"context"
-	"log"
+	"fmt"
 	"time"
 
-	"github.com/cdipaolo/sentiment"
 	"github.com/dghubble/go-twitter/twitter"
-	"github.com/grassmudhorses/vader-go/lexicon"
-	"github.com/grassmudhorses/vader-go/sentitext"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
+	"github.com/jmoussa/go-sentitweet/monitoring"
 )
 
 type TweetWithScoreMessage struct {
 	BaseTweet *twitter.Tweet
-	Score     interface{} //map[string]float64
-	Type      string
+	// Scores holds every score computed for the tweet keyed by scorer name (vader, imdb, ...)
+	Scores map[string]SentimentResult
+	// CreatedAt is BaseTweet.CreatedAt parsed, set by the store when the tweet is written
+	CreatedAt time.Time `bson:"created_at,omitempty"`
+	// IngestedAt is when the tweet was first stored
+	IngestedAt time.Time `bson:"ingested_at,omitempty"`
+	// Term is the search term the tweet was collected for, empty when unknown (replays, ...)
+	Term string `bson:"term,omitempty"`
 }
 
-func LexiconSentimentAnalysis(s interface{}) (interface{}, error) {
-	// Takes in an interface{} message, fetchest sentiment scores, and pushes updated message with scores
-	tweet := s.(*twitter.Tweet)
-	parseText := sentitext.Parse(tweet.Text, lexicon.DefaultLexicon)
-	results := sentitext.PolarityScore(parseText)
-	//log.Println("Positive:", results.Positive)
-	//log.Println("Negative:", results.Negative)
-	//log.Println("Neutral:", results.Neutral)
-	//log.Println("Compound:", results.Compound)
-	scores := map[string]float64{
-		"Positive": results.Positive,
-		"Negative": results.Negative,
-		"Neutral":  results.Neutral,
-		"Compound": results.Compound,
+// Merge folds the scores of other (for the same tweet) into m
+func (m *TweetWithScoreMessage) Merge(other TweetWithScoreMessage) {
+	if m.BaseTweet == nil {
+		m.BaseTweet = other.BaseTweet
+	}
+	if other.Term != "" {
+		m.Term = other.Term
+	}
+	if m.Scores == nil {
+		m.Scores = map[string]SentimentResult{}
+	}
+	for k, v := range other.Scores {
+		m.Scores[k] = v
 	}
-	log.Println("Lexicon Scores:", scores)
-	var obj TweetWithScoreMessage
-	obj.BaseTweet = tweet
-	obj.Score = scores
-	obj.Type = "lexicon"
-	return obj, nil
 }
 
-func FormatAndUpload(s interface{}) (interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI("mongodb://localhost:27017"))
-	defer func() {
-		if err = client.Disconnect(ctx); err != nil {
-			return
-		}
-	}()
-	collection := client.Database("twitter-sentiment").Collection("tweets")
+// ScoreTweet scores a *twitter.Tweet pipeline message with scorer
+func ScoreTweet(scorer Scorer, s interface{}) (TweetWithScoreMessage, error) {
+	tweet, ok := s.(*twitter.Tweet)
+	if !ok || tweet == nil {
+		return TweetWithScoreMessage{}, fmt.Errorf("%s: expected a *twitter.Tweet, got %T", scorer.Name(), s)
+	}
+	result, err := scorer.Score(tweet.Text)
+	if err != nil {
+		return TweetWithScoreMessage{}, fmt.Errorf("%s: could not score tweet %d: %w", scorer.Name(), tweet.ID, err)
+	}
+	monitoring.For("analysis").Debug().
+		Int64(monitoring.FieldTweetID, tweet.ID).
+		Str(monitoring.FieldScorer, scorer.Name()).
+		Float64("compound", result.Compound).
+		Str("label", result.Label).
+		Msg("Scored tweet")
+	return TweetWithScoreMessage{
+		BaseTweet: tweet,
+		Scores:    map[string]SentimentResult{scorer.Name(): result},
+	}, nil
+}
 
-	opts := options.Update().SetUpsert(true)
-	filter := bson.M{"basetweet.id": s.(TweetWithScoreMessage).BaseTweet.ID}
-	score := s.(TweetWithScoreMessage).Score
-	t := s.(TweetWithScoreMessage).Type
-	update := bson.M{"$set": bson.M{t: score, "basetweet": s.(TweetWithScoreMessage).BaseTweet}}
-	result, err := collection.UpdateOne(ctx, filter, update, opts)
+// ScoringStep returns a pipeline step scoring tweets with the named scorer
+func ScoringStep(name string) (func(interface{}) (interface{}, error), error) {
+	scorer, err := GetScorer(name)
 	if err != nil {
-		log.Fatalf("Error Upserting")
+		return nil, err
 	}
-	log.Println(result)
-	//collection.InsertOne(ctx, s)
-	// Takes in a string message, alters it and pushes updated message
-	//tweet := s.(TweetWithScore)
-	//log.Printf("Process 2: Text - %s\n--------------------------------\n", tweet.BaseTweet.Text)
-	return s, nil
+	return func(s interface{}) (interface{}, error) {
+		return ScoreTweet(scorer, s)
+	}, nil
+}
+
+func LexiconSentimentAnalysis(s interface{}) (interface{}, error) {
+	// Takes in an interface{} message, fetchest sentiment scores, and pushes updated message with scores
+	scorer, err := GetScorer("vader")
+	if err != nil {
+		return nil, err
+	}
+	obj, err := ScoreTweet(scorer, s)
+	if err != nil {
+		return nil, err
+	}
+	return obj, nil
 }
 
 // model trained on IMDB reviews
 func IMDBModelSentimentAnalysis(s interface{}) (interface{}, error) {
-	log.Println(s)
-	tweet := s.(*twitter.Tweet)
-	// Model : restore or train(project directory)
-	sentimentModel, err := sentiment.Restore()
+	scorer, err := GetScorer("imdb")
+	if err != nil {
+		return nil, err
+	}
+	obj, err := ScoreTweet(scorer, s)
 	if err != nil {
-		log.Printf("Error formatting model: %s", err)
-		return 0, err
-		//panic(err)
+		return nil, err
 	}
-	results := sentimentModel.SentimentAnalysis(tweet.Text, sentiment.English)
-	score := results.Score
-	log.Println("Score:", score)
-	var obj TweetWithScoreMessage
-	obj.BaseTweet = tweet
-	obj.Score = score
-	obj.Type = "imdb_ml_model"
 	return obj, nil
 }
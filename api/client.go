@@ -0,0 +1,59 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	analysis "github.com/jmoussa/go-sentitweet/analysis"
+	"github.com/jmoussa/go-sentitweet/db"
+)
+
+// Client calls the endpoints of a running API server
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+}
+
+func NewClient(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		HTTPClient: &http.Client{Timeout: 60 * time.Second},
+	}
+}
+
+// FindTweets fetches one page of POST /tweets, body.Fields is ignored so the tweets decode whole
+func (c *Client) FindTweets(ctx context.Context, body TweetSearchBody) (db.TweetPage, error) {
+	body.Fields = nil
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return db.TweetPage{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+"/tweets", bytes.NewReader(raw))
+	if err != nil {
+		return db.TweetPage{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return db.TweetPage{}, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Data       []analysis.TweetWithScoreMessage `json:"data"`
+		NextCursor string                           `json:"next_cursor"`
+		Error      string                           `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return db.TweetPage{}, fmt.Errorf("POST %s/tweets: %s: could not decode the response: %w", c.BaseURL, resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return db.TweetPage{}, fmt.Errorf("POST %s/tweets: %s: %s", c.BaseURL, resp.Status, result.Error)
+	}
+	return db.TweetPage{Tweets: result.Data, NextCursor: result.NextCursor}, nil
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dghubble/go-twitter/twitter"
+)
+
+func TestClientFindTweets(t *testing.T) {
+	now := time.Now().UTC()
+	server := httptest.NewServer(newTestRouter(t,
+		&twitter.Tweet{ID: 1, Text: "I love #golang", CreatedAt: now.Format(time.RubyDate)},
+		&twitter.Tweet{ID: 2, Text: "golang is fine", CreatedAt: now.Add(-time.Minute).Format(time.RubyDate)},
+		&twitter.Tweet{ID: 3, Text: "rainy monday", CreatedAt: now.Add(-2 * time.Minute).Format(time.RubyDate)},
+	))
+	defer server.Close()
+	client := NewClient(server.URL + "/")
+	ctx := context.Background()
+
+	body := TweetSearchBody{Limit: 2}
+	body.SearchPhrase = "golang"
+	page, err := client.FindTweets(ctx, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Tweets) != 2 || page.Tweets[0].BaseTweet.ID != 1 || page.Tweets[0].Scores["vader"].Label == "" {
+		t.Fatalf("unexpected page %+v", page)
+	}
+
+	page, err = client.FindTweets(ctx, TweetSearchBody{Limit: 2})
+	if err != nil || len(page.Tweets) != 2 || page.NextCursor == "" {
+		t.Fatalf("first page: %+v, %v", page, err)
+	}
+	page, err = client.FindTweets(ctx, TweetSearchBody{Limit: 2, Cursor: page.NextCursor})
+	if err != nil || len(page.Tweets) != 1 || page.Tweets[0].BaseTweet.ID != 3 || page.NextCursor != "" {
+		t.Fatalf("last page: %+v, %v", page, err)
+	}
+
+	_, err = client.FindTweets(ctx, TweetSearchBody{Sort: "nope"})
+	if err == nil || !strings.Contains(err.Error(), "400") || !strings.Contains(err.Error(), "unknown sort") {
+		t.Fatalf("expected the API error, got %v", err)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := client.FindTweets(canceled, TweetSearchBody{}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
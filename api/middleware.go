@@ -0,0 +1,95 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoussa/go-sentitweet/monitoring"
+	"github.com/rs/zerolog"
+)
+
+// RequestIDHeader carries the ID of a request, the client's when it sent a valid one
+const RequestIDHeader = "X-Request-ID"
+
+func logger(ctx context.Context) *zerolog.Logger {
+	return monitoring.Ctx(ctx, "api")
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// RequestLogger gives every request an ID, carried by its context into the storage calls (and their logs),
+// and logs the request once it is served
+func RequestLogger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		c.Header(RequestIDHeader, id)
+		c.Request = c.Request.WithContext(monitoring.WithRequestID(c.Request.Context(), id))
+		started := time.Now()
+
+		c.Next()
+
+		status := c.Writer.Status()
+		log := logger(c.Request.Context())
+		e := log.Info()
+		switch {
+		case status >= 500:
+			e = log.Error()
+		case status >= 400:
+			e = log.Warn()
+		}
+		if len(c.Errors) > 0 {
+			e = e.Str("errors", c.Errors.String())
+		}
+		e.Str("method", c.Request.Method).
+			Str("route", route(c)).
+			Str("path", c.Request.URL.Path).
+			Int("status", status).
+			Dur(monitoring.FieldLatency, time.Since(started)).
+			Str("client_ip", c.ClientIP()).
+			Msg("Request")
+	}
+}
+
+// route is the route pattern of the request, not its path, to keep the metric labels bounded
+func route(c *gin.Context) string {
+	if path := c.FullPath(); path != "" {
+		return path
+	}
+	return "unmatched"
+}
+
+// RequestMetrics counts and times the requests by route, method and status
+func RequestMetrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		started := time.Now()
+		c.Next()
+		labels := []string{route(c), c.Request.Method, strconv.Itoa(c.Writer.Status())}
+		monitoring.HTTPRequests.WithLabelValues(labels...).Inc()
+		monitoring.HTTPRequestDuration.WithLabelValues(labels...).Observe(time.Since(started).Seconds())
+	}
+}
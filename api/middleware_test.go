@@ -0,0 +1,70 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoussa/go-sentitweet/analysis"
+	"github.com/jmoussa/go-sentitweet/config"
+	"github.com/jmoussa/go-sentitweet/db"
+	"github.com/jmoussa/go-sentitweet/monitoring"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// requestIDStore records the request ID its Get calls carry
+type requestIDStore struct {
+	*db.MemoryStore
+	requestID string
+}
+
+func (s *requestIDStore) Get(ctx context.Context, id int64) (analysis.TweetWithScoreMessage, error) {
+	s.requestID = monitoring.RequestID(ctx)
+	return s.MemoryStore.Get(ctx, id)
+}
+
+func TestRequestLogger(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := &requestIDStore{MemoryStore: db.NewMemoryStore()}
+	r, err := NewRouter(store, nil, config.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/tweet/1", nil)
+	req.Header.Set(RequestIDHeader, "client-chosen.id_1")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if got := w.Header().Get(RequestIDHeader); got != "client-chosen.id_1" || store.requestID != got {
+		t.Fatalf("expected the client's request ID in the response and the store, got %q and %q", got, store.requestID)
+	}
+
+	// invalid IDs are replaced rather than logged as is
+	req = httptest.NewRequest(http.MethodGet, "/tweet/1", nil)
+	req.Header.Set(RequestIDHeader, "bad id\n")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	got := w.Header().Get(RequestIDHeader)
+	if !validRequestID(got) || got == "bad id\n" || store.requestID != got {
+		t.Fatalf("expected a generated request ID, got %q and %q", got, store.requestID)
+	}
+}
+
+func TestRequestMetrics(t *testing.T) {
+	r := newTestRouter(t)
+	requests := monitoring.HTTPRequests.WithLabelValues("/tweet/:id", http.MethodGet, "404")
+	before := testutil.ToFloat64(requests)
+	doRequest(r, http.MethodGet, "/tweet/1", "")
+	doRequest(r, http.MethodGet, "/tweet/2", "")
+	if got := testutil.ToFloat64(requests) - before; got != 2 {
+		t.Fatalf("expected 2 requests counted under the route, got %v", got)
+	}
+	unmatched := monitoring.HTTPRequests.WithLabelValues("unmatched", http.MethodGet, "404")
+	before = testutil.ToFloat64(unmatched)
+	doRequest(r, http.MethodGet, "/no/such/route", "")
+	if got := testutil.ToFloat64(unmatched) - before; got != 1 {
+		t.Fatalf("expected the unknown path to be counted as unmatched, got %v", got)
+	}
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoussa/go-sentitweet/analysis"
+	"github.com/jmoussa/go-sentitweet/config"
+)
+
+const (
+	defaultScoreMaxBatch = 100
+	scoreTimeout         = 30 * time.Second
+)
+
+type ScoreBody struct {
+	Texts []string `json:"texts"`
+	// Scorers are the analysis scorers to run, default vader
+	Scorers []string `json:"scorers,omitempty"`
+}
+
+type TextScores struct {
+	Text   string                              `json:"text"`
+	Scores map[string]analysis.SentimentResult `json:"scores"`
+}
+
+// scoreMaxBatch reads score_max_batch, the most texts a single POST /score may hold
+func scoreMaxBatch(cfg config.Config) (int, error) {
+	raw := cfg.General["score_max_batch"]
+	if raw == "" {
+		return defaultScoreMaxBatch, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid score_max_batch %q", raw)
+	}
+	return n, nil
+}
+
+// POST /score
+// Score arbitrary texts with the analysis scorers
+func ScoreTexts(maxBatch int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var requestBody ScoreBody
+		if err := c.ShouldBindJSON(&requestBody); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Failed to parse HTTP Request Body with error: %s", err)})
+			return
+		}
+		if len(requestBody.Texts) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "texts is empty"})
+			return
+		}
+		if len(requestBody.Texts) > maxBatch {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("at most %d texts can be scored per request, got %d", maxBatch, len(requestBody.Texts))})
+			return
+		}
+		if len(requestBody.Scorers) == 0 {
+			requestBody.Scorers = []string{"vader"}
+		}
+		scorers := make([]analysis.Scorer, 0, len(requestBody.Scorers))
+		for _, name := range requestBody.Scorers {
+			scorer, err := analysis.GetScorer(name)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "available": analysis.ScorerNames()})
+				return
+			}
+			scorers = append(scorers, scorer)
+		}
+
+		// stops scoring as soon as the client goes away
+		ctx, cancel := context.WithTimeout(c.Request.Context(), scoreTimeout)
+		defer cancel()
+		results, err := analysis.ScoreAll(ctx, requestBody.Texts, scorers)
+		if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": fmt.Sprintf("scoring stopped: %s", err)})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		data := make([]TextScores, len(results))
+		for i, scores := range results {
+			data[i] = TextScores{Text: requestBody.Texts[i], Scores: scores}
+		}
+		c.JSON(http.StatusOK, gin.H{"data": data, "count": len(data)})
+	}
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/jmoussa/go-sentitweet/analysis"
+)
+
+func TestScoreTexts(t *testing.T) {
+	r := newTestRouter(t)
+
+	code, body := doRequest(r, http.MethodPost, "/score", `{"texts": ["I love this app", "worst support ever"]}`)
+	if code != http.StatusOK || body["count"] != float64(2) {
+		t.Fatalf("unexpected response %d %v", code, body)
+	}
+	results := body["data"].([]interface{})
+	first := results[0].(map[string]interface{})
+	vader := first["scores"].(map[string]interface{})["vader"].(map[string]interface{})
+	if first["text"] != "I love this app" || vader["label"] != analysis.LabelPositive {
+		t.Errorf("unexpected result %v", first)
+	}
+
+	tests := []struct {
+		body string
+		code int
+	}{
+		{`{"texts": []}`, http.StatusBadRequest},
+		{`{"texts": ["a"], "scorers": ["nope"]}`, http.StatusBadRequest},
+		// the test router allows 3 texts per request
+		{`{"texts": ["a", "b", "c", "d"]}`, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		if code, body := doRequest(r, http.MethodPost, "/score", tt.body); code != tt.code {
+			t.Errorf("%s: expected %d, got %d %v", tt.body, tt.code, code, body)
+		}
+	}
+}
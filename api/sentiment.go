@@ -0,0 +1,58 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoussa/go-sentitweet/db"
+)
+
+// GET /sentiment/timeseries?term=...&bucket=1h&from=...&to=...&scorer=vader
+// Per bucket tweet counts, compound score statistics and label proportions
+// from defaults to db.DefaultTimeSeriesWindow before to, at most db.MaxBuckets buckets
+func SentimentTimeSeries(store db.TweetStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query := db.TimeSeriesQuery{}
+		query.Term = c.Query("term")
+		query.Scorer = c.Query("scorer")
+		query.SearchPhrase = c.Query("searchPhrase")
+		var err error
+		if raw := c.Query("bucket"); raw != "" {
+			if query.Bucket, err = time.ParseDuration(raw); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid bucket %q", raw)})
+				return
+			}
+		}
+		for name, bound := range map[string]*time.Time{"from": &query.From, "to": &query.To} {
+			raw := c.Query(name)
+			if raw == "" {
+				continue
+			}
+			if *bound, err = time.Parse(time.RFC3339, raw); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid %s %q, expected an RFC 3339 date", name, raw)})
+				return
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
+		defer cancel()
+		buckets, err := store.TimeSeries(ctx, query)
+		if errors.Is(err, db.ErrInvalidQuery) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		bucket := query.Bucket
+		if bucket == 0 {
+			bucket = db.DefaultBucket
+		}
+		c.JSON(http.StatusOK, gin.H{"data": buckets, "bucket": bucket.String()})
+	}
+}
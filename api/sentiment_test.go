@@ -0,0 +1,37 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/dghubble/go-twitter/twitter"
+)
+
+func TestSentimentTimeSeries(t *testing.T) {
+	start := time.Date(2022, 5, 1, 10, 0, 0, 0, time.UTC)
+	r := newTestRouter(t,
+		&twitter.Tweet{ID: 1, Text: "what a great day", CreatedAt: start.Add(time.Minute).Format(time.RubyDate)},
+		&twitter.Tweet{ID: 2, Text: "what a terrible day", CreatedAt: start.Add(2 * time.Minute).Format(time.RubyDate)},
+		&twitter.Tweet{ID: 3, Text: "ok", CreatedAt: start.Add(3 * time.Hour).Format(time.RubyDate)},
+	)
+
+	code, body := doRequest(r, http.MethodGet, "/sentiment/timeseries?bucket=1h&from=2022-05-01T00:00:00Z&to=2022-05-01T12:00:00Z", "")
+	if code != http.StatusOK || body["bucket"] != "1h0m0s" {
+		t.Fatalf("unexpected response %d %v", code, body)
+	}
+	buckets := body["data"].([]interface{})
+	if len(buckets) != 1 {
+		t.Fatalf("expected one bucket before 12:00, got %v", buckets)
+	}
+	first := buckets[0].(map[string]interface{})
+	if first["count"] != float64(2) || first["positive"] != 0.5 || first["negative"] != 0.5 {
+		t.Errorf("unexpected bucket %v", first)
+	}
+
+	for _, query := range []string{"bucket=soon", "from=yesterday", "bucket=1ms", "scorer=a.b"} {
+		if code, _ := doRequest(r, http.MethodGet, "/sentiment/timeseries?"+query, ""); code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, code)
+		}
+	}
+}
@@ -1,24 +1,108 @@
 package api
 
 import (
-	"log"
+	"context"
 	"net/http"
+	"time"
 
 	"github.com/arl/statsviz"
 	"github.com/gin-gonic/gin"
+	"github.com/jmoussa/go-sentitweet/config"
+	"github.com/jmoussa/go-sentitweet/db"
+	"github.com/jmoussa/go-sentitweet/monitoring"
+	"github.com/jmoussa/go-sentitweet/stream"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-func RunServer() {
-	r := gin.Default()
-	r.POST("/tweets", FindTweets)
-	r.GET("/tweet/:id", FindTweet)
+// NewRouter registers the API routes on top of store, /stream and /ws serve the tweets published to hub (unavailable when nil)
+func NewRouter(store db.TweetStore, hub *stream.Hub, cfg config.Config) (*gin.Engine, error) {
+	maxBatch, err := scoreMaxBatch(cfg)
+	if err != nil {
+		return nil, err
+	}
+	heartbeat, err := streamHeartbeat(cfg)
+	if err != nil {
+		return nil, err
+	}
+	liveFeed, err := liveFeedOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
+	r := gin.New()
+	r.Use(RequestLogger(), RequestMetrics(), gin.Recovery())
+	r.POST("/tweets", FindTweets(store))
+	r.GET("/tweet/:id", FindTweet(store))
+	r.GET("/sentiment/timeseries", SentimentTimeSeries(store))
+	r.GET("/stream", StreamTweets(hub, heartbeat))
+	r.GET("/ws", LiveFeed(hub, liveFeed))
+	r.POST("/score", ScoreTexts(maxBatch))
 	r.GET("/logs", PipeLogs)
+	return r, nil
+}
+
+// RunServer runs the API on its own, live tweets come from the store's change stream when it has one
+func RunServer() {
+	runServer(stream.NewHub(), true)
+}
+
+// RunServerWithHub runs the API next to a pipeline in the same process, which publishes its tweets to hub
+func RunServerWithHub(hub *stream.Hub) {
+	runServer(hub, false)
+}
+
+func runServer(hub *stream.Hub, standalone bool) {
+	// one store (and mongo connection pool) shared by every request
+	cfg := config.ParseConfig()
+	if err := monitoring.ConfigureLogging(cfg); err != nil {
+		logger(context.Background()).Fatal().Err(err).Msg("Invalid logging config")
+	}
+	log := logger(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	store, err := db.OpenStore(ctx, cfg)
+	cancel()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Could not open storage")
+	}
+	defer store.Close(context.Background())
+
+	r, err := NewRouter(store, hub, cfg)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid server config")
+	}
+	if !standalone {
+		// the pipeline serves statsviz and /metrics on :6070
+		r.Run()
+		return
+	}
+	if watcher, ok := store.(db.TweetWatcher); ok {
+		go tailStore(context.Background(), watcher, hub)
+	} else {
+		log.Warn().Msg("The storage backend can't be watched, /stream and /ws only have live tweets with `tw pipeline --server`")
+	}
 	// use statsviz for program health visualization
 	statsviz.RegisterDefault()
+	http.Handle("/metrics", promhttp.Handler())
 	go func() {
-		// stat viz for the server is available on port :6060
-		log.Println("Navigate to: http://localhost:6060/debug/statsviz/ for metrics")
-		log.Println(http.ListenAndServe("localhost:6060", nil))
+		// stat viz and the Prometheus metrics of the server are available on port :6060
+		log.Info().Msg("Navigate to: http://localhost:6060/debug/statsviz/ for runtime graphs, Prometheus metrics are on /metrics")
+		log.Error().Err(http.ListenAndServe("localhost:6060", nil)).Msg("Debug server stopped")
 	}()
 	r.Run()
 }
+
+// tailStore publishes the tweets written by other processes, watching again after a failure
+func tailStore(ctx context.Context, watcher db.TweetWatcher, hub *stream.Hub) {
+	const retryAfter = 30 * time.Second
+	for {
+		err := watcher.Watch(ctx, hub.Publish)
+		if ctx.Err() != nil {
+			return
+		}
+		logger(ctx).Warn().Err(err).Dur("retry_after", retryAfter).Msg("Live tweets unavailable, watching again later")
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryAfter):
+		}
+	}
+}
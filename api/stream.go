@@ -0,0 +1,108 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoussa/go-sentitweet/analysis"
+	"github.com/jmoussa/go-sentitweet/config"
+	"github.com/jmoussa/go-sentitweet/db"
+	"github.com/jmoussa/go-sentitweet/stream"
+)
+
+const defaultStreamHeartbeat = 15 * time.Second
+
+// streamHeartbeat reads "stream_heartbeat" from the config, how often idle streams get a heartbeat event
+func streamHeartbeat(cfg config.Config) (time.Duration, error) {
+	raw := cfg.General["stream_heartbeat"]
+	if raw == "" {
+		return defaultStreamHeartbeat, nil
+	}
+	heartbeat, err := time.ParseDuration(raw)
+	if err != nil || heartbeat <= 0 {
+		return 0, fmt.Errorf("invalid stream_heartbeat %q, expected a positive duration", raw)
+	}
+	return heartbeat, nil
+}
+
+// streamFilter reads the filter of a live stream from the query string
+func streamFilter(c *gin.Context) (db.TweetFilter, error) {
+	filter := db.TweetFilter{
+		SearchPhrase: c.Query("search"),
+		Term:         c.Query("term"),
+		Scorer:       c.Query("scorer"),
+		Label:        c.Query("label"),
+		Author:       c.Query("author"),
+		Hashtags:     c.QueryArray("hashtag"),
+		Lang:         c.Query("lang"),
+	}
+	for name, bound := range map[string]**float64{"min_compound": &filter.MinCompound, "max_compound": &filter.MaxCompound} {
+		raw := c.Query(name)
+		if raw == "" {
+			continue
+		}
+		value, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return filter, fmt.Errorf("%w: invalid %s %q", db.ErrInvalidQuery, name, raw)
+		}
+		*bound = &value
+	}
+	return filter, nil
+}
+
+// GET /stream?term=...&min_compound=...&max_compound=...&label=...&scorer=...&author=...&hashtag=...&lang=...&search=...
+// Server-Sent Events: a "tweet" event per newly scored tweet matching the filter, "heartbeat" events while idle
+func StreamTweets(hub *stream.Hub, heartbeat time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if hub == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "live tweets aren't available on this server"})
+			return
+		}
+		filter, err := streamFilter(c)
+		var match func(analysis.TweetWithScoreMessage) bool
+		if err == nil {
+			match, err = filter.Matcher()
+		}
+		if errors.Is(err, db.ErrInvalidQuery) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		sub := hub.Subscribe(stream.DefaultBuffer)
+		defer sub.Close()
+		ticker := time.NewTicker(heartbeat)
+		defer ticker.Stop()
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		// don't let reverse proxies buffer the events
+		c.Header("X-Accel-Buffering", "no")
+		c.SSEvent("heartbeat", gin.H{"time": time.Now().UTC()})
+		c.Stream(func(w io.Writer) bool {
+			select {
+			case <-c.Request.Context().Done():
+				return false
+			case msg, ok := <-sub.C:
+				if !ok {
+					return false
+				}
+				if match(msg) {
+					c.SSEvent("tweet", msg)
+				}
+				return true
+			case now := <-ticker.C:
+				// dropped counts the tweets this client was too slow to receive
+				c.SSEvent("heartbeat", gin.H{"time": now.UTC(), "dropped": sub.Dropped()})
+				return true
+			}
+		})
+	}
+}
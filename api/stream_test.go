@@ -0,0 +1,135 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dghubble/go-twitter/twitter"
+	"github.com/jmoussa/go-sentitweet/analysis"
+	"github.com/jmoussa/go-sentitweet/config"
+	"github.com/jmoussa/go-sentitweet/db"
+	"github.com/jmoussa/go-sentitweet/stream"
+)
+
+type sseEvent struct {
+	name, data string
+}
+
+// readEvents parses the Server-Sent Events of body onto a channel
+func readEvents(body *bufio.Reader) <-chan sseEvent {
+	events := make(chan sseEvent)
+	go func() {
+		defer close(events)
+		var event sseEvent
+		for {
+			line, err := body.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\n")
+			switch {
+			case strings.HasPrefix(line, "event:"):
+				event.name = strings.TrimPrefix(line, "event:")
+			case strings.HasPrefix(line, "data:"):
+				event.data = strings.TrimPrefix(line, "data:")
+			case line == "":
+				events <- event
+				event = sseEvent{}
+			}
+		}
+	}()
+	return events
+}
+
+func nextEvent(t *testing.T, events <-chan sseEvent, name string) sseEvent {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				t.Fatalf("stream closed waiting for a %s event", name)
+			}
+			if event.name == name {
+				return event
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for a %s event", name)
+		}
+	}
+}
+
+func TestStreamTweets(t *testing.T) {
+	hub := stream.NewHub()
+	r, err := NewRouter(db.NewMemoryStore(), hub, config.Config{General: map[string]string{"stream_heartbeat": "50ms"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/stream?term=%23golang&min_compound=0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		t.Fatalf("unexpected response %s %s", resp.Status, resp.Header.Get("Content-Type"))
+	}
+	events := readEvents(bufio.NewReader(resp.Body))
+	nextEvent(t, events, "heartbeat")
+
+	publish := func(id int64, text, term string) {
+		msg, err := analysis.ScoreTweet(analysis.VaderScorer{}, &twitter.Tweet{ID: id, Text: text})
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg.Term = term
+		hub.Publish(msg)
+	}
+	publish(1, "I love this", "#rust")
+	publish(2, "I hate this", "#golang")
+	publish(3, "I love this", "#golang")
+	var msg analysis.TweetWithScoreMessage
+	if err := json.Unmarshal([]byte(nextEvent(t, events, "tweet").data), &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.BaseTweet.ID != 3 {
+		t.Fatalf("expected only tweet 3 to match, got %d", msg.BaseTweet.ID)
+	}
+	// idle streams keep getting heartbeats
+	nextEvent(t, events, "heartbeat")
+
+	resp.Body.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for hub.Subscribers() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the subscription outlived the client")
+		}
+		publish(4, "anyone there?", "")
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStreamTweetsErrors(t *testing.T) {
+	r, err := NewRouter(db.NewMemoryStore(), stream.NewHub(), config.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/stream?min_compound=high", "/stream?label=ecstatic"} {
+		if code, _ := doRequest(r, http.MethodGet, path, ""); code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", path, code)
+		}
+	}
+	if code, _ := doRequest(newTestRouter(t), http.MethodGet, "/stream", ""); code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 without a hub, got %d", code)
+	}
+	if _, err := NewRouter(db.NewMemoryStore(), nil, config.Config{General: map[string]string{"stream_heartbeat": "0s"}}); err == nil {
+		t.Error("expected an invalid stream_heartbeat to be rejected")
+	}
+}
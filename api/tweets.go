@@ -3,9 +3,11 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -13,85 +15,134 @@ import (
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/gin-gonic/gin"
 	analysis "github.com/jmoussa/go-sentitweet/analysis"
-	"github.com/jmoussa/go-sentitweet/config"
 	"github.com/jmoussa/go-sentitweet/db"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// TweetSearchBody is the filter (searchPhrase, daysBack, from, to, author, hashtags, ...) of db.TweetFilter plus paging
 type TweetSearchBody struct {
-	SearchPhrase string `json:"searchPhrase,omitempty"`
-	DaysBack     int    `json:"daysBack,omitempty"`
+	db.TweetFilter
+	// Limit is the page size (default 100, at most 1000)
+	Limit int `json:"limit,omitempty"`
+	// Cursor is the next_cursor of the previous response
+	Cursor string `json:"cursor,omitempty"`
+	// Sort is "date" (default, newest first) or "compound" (highest score of the filter's scorer first)
+	Sort string `json:"sort,omitempty"`
+	// Fields keeps only these fields of each tweet, dotted for nested ones (BaseTweet.text, Scores.vader, ...)
+	Fields []string `json:"fields,omitempty"`
 }
 
 // POST /tweets
-// Get all tweets
-func FindTweets(c *gin.Context) {
-	var requestBody TweetSearchBody
-	if err := c.BindJSON(&requestBody); err != nil {
-		//log.Fatalf("Error: %s", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to parse HTTP Request Body with error: %s", err)})
-		return
+// Search tweets, one page at a time
+func FindTweets(store db.TweetStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var requestBody TweetSearchBody
+		if err := c.BindJSON(&requestBody); err != nil {
+			//log.Fatalf("Error: %s", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to parse HTTP Request Body with error: %s", err)})
+			return
+		}
+		// init db context context
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+		query := db.TweetQuery{
+			TweetFilter: requestBody.TweetFilter,
+			Sort:        requestBody.Sort,
+			Limit:       requestBody.Limit,
+			Cursor:      requestBody.Cursor,
+			Fields:      topLevelFields(requestBody.Fields),
+		}
+		page, err := store.Find(ctx, query)
+		if errors.Is(err, db.ErrInvalidQuery) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		logger(ctx).Debug().Str("search", requestBody.SearchPhrase).Int("tweets", len(page.Tweets)).Msg("Tweets found")
+		var data interface{} = page.Tweets
+		if len(requestBody.Fields) > 0 {
+			if data, err = projectFields(page.Tweets, requestBody.Fields); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{"data": data, "count": len(page.Tweets), "next_cursor": page.NextCursor})
 	}
-	log.Println("Request: ", requestBody.SearchPhrase)
-	// init db context context
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	// init db connection
-	client, err := db.OpenMongoClient(ctx)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("could not disconnect mongo client with error: %s", err)})
+}
+
+func topLevelFields(fields []string) []string {
+	top := make([]string, 0, len(fields))
+	for _, field := range fields {
+		top = append(top, strings.SplitN(field, ".", 2)[0])
+	}
+	return top
+}
+
+// projectFields keeps the listed (dotted) paths of the JSON encoding of each tweet
+func projectFields(tweets []analysis.TweetWithScoreMessage, fields []string) ([]map[string]interface{}, error) {
+	projected := make([]map[string]interface{}, len(tweets))
+	for i, tweet := range tweets {
+		raw, err := json.Marshal(tweet)
+		if err != nil {
+			return nil, err
+		}
+		var full map[string]interface{}
+		if err := json.Unmarshal(raw, &full); err != nil {
+			return nil, err
+		}
+		projected[i] = map[string]interface{}{}
+		for _, field := range fields {
+			copyPath(projected[i], full, strings.Split(field, "."))
+		}
+	}
+	return projected, nil
+}
+
+func copyPath(dst, src map[string]interface{}, path []string) {
+	value, ok := src[path[0]]
+	if !ok {
 		return
 	}
-	// defer closing of db conn
-	defer db.CloseMongoClient(client, ctx)
-	// text search
-	var (
-		tweets          []analysis.TweetWithScoreMessage
-		additional_desc string
-	)
-	if requestBody.SearchPhrase != "" {
-		tweets, err, additional_desc = db.TextSearchQueryMongoClient(client, ctx, requestBody.SearchPhrase)
-	} else if requestBody.DaysBack > 0 {
-		tweets, err, additional_desc = db.FetchRecentTweets(client, ctx, requestBody.DaysBack)
+	if len(path) == 1 {
+		dst[path[0]] = value
+		return
 	}
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("%s: %s", additional_desc, err)})
+	nested, ok := value.(map[string]interface{})
+	if !ok {
 		return
 	}
-	// return
-	log.Println(len(tweets), " Tweets Found")
-	c.JSON(http.StatusOK, gin.H{"data": tweets, "count": len(tweets)})
+	into, ok := dst[path[0]].(map[string]interface{})
+	if !ok {
+		into = map[string]interface{}{}
+		dst[path[0]] = into
+	}
+	copyPath(into, nested, path[1:])
 }
 
 // GET /tweet/:id
 // Find a tweet by id
-func FindTweet(c *gin.Context) { // Get model if exist
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	var cfg config.Config = config.ParseConfig()
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(cfg.General["mongo_url_string"]))
-	defer func() {
-		if err = client.Disconnect(ctx); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to disconnect mongo client with error: %s", err)})
-			panic(err)
+func FindTweet(store db.TweetStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid tweet id %q", c.Param("id"))})
+			return
 		}
-	}()
-	collection := client.Database("twitter-sentiment").Collection("tweets")
-
-	filterCursor, err := collection.Find(ctx, bson.M{"basetweet.id": c.Param("id")})
-	if err != nil {
-		log.Fatal(err)
-	}
-	var tweets []analysis.TweetWithScoreMessage
-	if err = filterCursor.All(ctx, &tweets); err != nil {
-		log.Fatal(err)
-	}
-	for _, x := range tweets {
-		log.Println(x.BaseTweet.Text)
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+		tweet, err := store.Get(ctx, id)
+		if errors.Is(err, db.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": tweet})
 	}
-	c.JSON(http.StatusOK, gin.H{"data": tweets})
 }
 
 // GET /logs
@@ -116,23 +167,26 @@ func PipeLogs(c *gin.Context) {
 		VisibilityTimeout:   &waitTime,
 	})
 	if err != nil {
-		log.Fatal(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
 	data := make([]interface{}, 0)
 	for _, message := range result.Messages {
 		// remove excapes
 		raw_message, err := json.Marshal(*message.Body)
 		if err != nil {
-			log.Fatal(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
 		}
 		var logjson interface{}
 		err = json.Unmarshal([]byte(raw_message), &logjson)
 		if err != nil {
-			log.Fatal(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
 		}
 		// append to return slice
 		data = append(data, logjson)
 	}
-	fmt.Printf("Retrieved %d messages\n", len(data))
+	logger(c.Request.Context()).Debug().Int("messages", len(data)).Msg("Retrieved pipeline logs")
 	c.JSON(http.StatusOK, gin.H{"data": data})
 }
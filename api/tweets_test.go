@@ -0,0 +1,112 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dghubble/go-twitter/twitter"
+	"github.com/gin-gonic/gin"
+	"github.com/jmoussa/go-sentitweet/analysis"
+	"github.com/jmoussa/go-sentitweet/config"
+	"github.com/jmoussa/go-sentitweet/db"
+)
+
+func newTestRouter(t *testing.T, tweets ...*twitter.Tweet) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	store := db.NewMemoryStore()
+	msgs := make([]analysis.TweetWithScoreMessage, len(tweets))
+	for i, tweet := range tweets {
+		msg, err := analysis.ScoreTweet(analysis.VaderScorer{}, tweet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs[i] = msg
+	}
+	for _, err := range store.UpsertMany(context.Background(), msgs) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err := NewRouter(store, nil, config.Config{General: map[string]string{"score_max_batch": "3"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func doRequest(r *gin.Engine, method, path, body string) (int, map[string]interface{}) {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+	var decoded map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &decoded)
+	return w.Code, decoded
+}
+
+func TestFindTweets(t *testing.T) {
+	now := time.Now().UTC()
+	r := newTestRouter(t,
+		&twitter.Tweet{ID: 1, Text: "I love #golang", CreatedAt: now.Format(time.RubyDate)},
+		&twitter.Tweet{ID: 2, Text: "rainy monday", CreatedAt: now.AddDate(0, 0, -10).Format(time.RubyDate)},
+	)
+
+	code, body := doRequest(r, http.MethodPost, "/tweets", `{"searchPhrase": "golang"}`)
+	if code != http.StatusOK || body["count"] != float64(1) {
+		t.Fatalf("search: unexpected response %d %v", code, body)
+	}
+
+	code, body = doRequest(r, http.MethodPost, "/tweets", `{"daysBack": 5}`)
+	if code != http.StatusOK || body["count"] != float64(1) {
+		t.Fatalf("daysBack: unexpected response %d %v", code, body)
+	}
+}
+
+func TestFindTweetsPages(t *testing.T) {
+	now := time.Now().UTC()
+	r := newTestRouter(t,
+		&twitter.Tweet{ID: 1, Text: "first", CreatedAt: now.Add(-time.Hour).Format(time.RubyDate)},
+		&twitter.Tweet{ID: 2, Text: "second", CreatedAt: now.Format(time.RubyDate)},
+	)
+
+	code, body := doRequest(r, http.MethodPost, "/tweets", `{"limit": 1, "fields": ["BaseTweet.text"]}`)
+	if code != http.StatusOK || body["count"] != float64(1) || body["next_cursor"] == "" {
+		t.Fatalf("first page: unexpected response %d %v", code, body)
+	}
+	tweet := body["data"].([]interface{})[0].(map[string]interface{})
+	if len(tweet) != 1 || tweet["BaseTweet"].(map[string]interface{})["text"] != "second" {
+		t.Errorf("expected only the text of the newest tweet, got %v", tweet)
+	}
+
+	code, body = doRequest(r, http.MethodPost, "/tweets", `{"limit": 1, "cursor": "`+body["next_cursor"].(string)+`"}`)
+	if code != http.StatusOK || body["count"] != float64(1) || body["next_cursor"] != "" {
+		t.Fatalf("last page: unexpected response %d %v", code, body)
+	}
+
+	if code, _ := doRequest(r, http.MethodPost, "/tweets", `{"sort": "random"}`); code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an unknown sort, got %d", code)
+	}
+}
+
+func TestFindTweet(t *testing.T) {
+	r := newTestRouter(t, &twitter.Tweet{ID: 42, Text: "what a great day"})
+
+	code, body := doRequest(r, http.MethodGet, "/tweet/42", "")
+	if code != http.StatusOK {
+		t.Fatalf("unexpected status %d %v", code, body)
+	}
+	scores := body["data"].(map[string]interface{})["Scores"].(map[string]interface{})
+	if scores["vader"].(map[string]interface{})["label"] != analysis.LabelPositive {
+		t.Errorf("unexpected scores %v", scores)
+	}
+
+	if code, _ := doRequest(r, http.MethodGet, "/tweet/7", ""); code != http.StatusNotFound {
+		t.Errorf("expected 404 for a missing tweet, got %d", code)
+	}
+	if code, _ := doRequest(r, http.MethodGet, "/tweet/abc", ""); code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid id, got %d", code)
+	}
+}
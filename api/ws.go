@@ -0,0 +1,350 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/jmoussa/go-sentitweet/analysis"
+	"github.com/jmoussa/go-sentitweet/config"
+	"github.com/jmoussa/go-sentitweet/db"
+	"github.com/jmoussa/go-sentitweet/stream"
+)
+
+/*
+WebSocket live feed (GET /ws). Over one connection a client manages named subscriptions, each a db.TweetFilter:
+
+	{"action": "subscribe", "id": "negative-golang", "filter": {"term": "#golang", "maxCompound": -0.5}}
+	{"action": "unsubscribe", "id": "negative-golang"}
+
+and receives
+  - {"type": "subscribed"|"unsubscribed", "id": ...} acknowledgements, {"type": "error", "id": ..., "error": ...}
+  - {"type": "tweet", "subscriptions": [ids...], "tweet": {...}} once per tweet matching any subscription
+  - {"type": "snapshot", ...} every ws_snapshot_interval: count, mean compound and labels of each subscription's
+    tweets since the previous snapshot, and how many tweets the client missed
+
+A client that reads too slowly misses tweets (snapshots still count them), while snapshots waiting to be sent are
+coalesced into the latest one.
+*/
+
+const (
+	defaultSnapshotInterval = 10 * time.Second
+	// tweets waiting to be written to a connection before newer ones are dropped
+	wsQueueSize = 64
+	// subscriptions one connection may hold
+	wsMaxSubscriptions = 20
+	wsMaxMessageSize   = 64 * 1024
+	wsWriteTimeout     = 10 * time.Second
+	wsPongTimeout      = 60 * time.Second
+	wsPingInterval     = wsPongTimeout * 9 / 10
+)
+
+// LiveFeedOptions are the /ws settings of the config: ws_snapshot_interval and ws_allowed_origins
+type LiveFeedOptions struct {
+	SnapshotInterval time.Duration
+	// AllowedOrigins are the origins browsers may connect from besides the server's own, "*" for any
+	AllowedOrigins []string
+}
+
+func liveFeedOptions(cfg config.Config) (LiveFeedOptions, error) {
+	opts := LiveFeedOptions{SnapshotInterval: defaultSnapshotInterval}
+	if raw := cfg.General["ws_snapshot_interval"]; raw != "" {
+		interval, err := time.ParseDuration(raw)
+		if err != nil || interval <= 0 {
+			return opts, fmt.Errorf("invalid ws_snapshot_interval %q, expected a positive duration", raw)
+		}
+		opts.SnapshotInterval = interval
+	}
+	for _, origin := range strings.Split(cfg.General["ws_allowed_origins"], ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			opts.AllowedOrigins = append(opts.AllowedOrigins, origin)
+		}
+	}
+	return opts, nil
+}
+
+// checkOrigin accepts the server's own origin (like gorilla's default) and the allowed ones
+func (o LiveFeedOptions) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range o.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return strings.EqualFold(strings.TrimPrefix(strings.TrimPrefix(origin, "https://"), "http://"), r.Host)
+}
+
+type wsCommand struct {
+	Action string         `json:"action"`
+	ID     string         `json:"id"`
+	Filter db.TweetFilter `json:"filter"`
+}
+
+type wsMessage struct {
+	Type          string                          `json:"type"`
+	ID            string                          `json:"id,omitempty"`
+	Error         string                          `json:"error,omitempty"`
+	Subscriptions []string                        `json:"subscriptions,omitempty"`
+	Tweet         *analysis.TweetWithScoreMessage `json:"tweet,omitempty"`
+	Snapshot      *wsSnapshot                     `json:"snapshot,omitempty"`
+}
+
+type wsSnapshot struct {
+	Time time.Time `json:"time"`
+	// Window is how long the summaries cover, since the previous snapshot
+	Window string `json:"window"`
+	// Summaries of each subscription's matching tweets in the window, by subscription ID
+	Summaries map[string]db.ScoreSummary `json:"summaries"`
+	// Dropped is the number of tweets the client missed since it connected
+	Dropped int64 `json:"dropped"`
+}
+
+type wsSubscription struct {
+	match   func(analysis.TweetWithScoreMessage) bool
+	scorer  string
+	summary db.ScoreSummary
+	total   float64
+}
+
+func (s *wsSubscription) add(msg analysis.TweetWithScoreMessage) {
+	s.summary.Count++
+	score, ok := msg.Scores[s.scorer]
+	if !ok {
+		return
+	}
+	s.total += score.Compound
+	switch score.Label {
+	case analysis.LabelPositive:
+		s.summary.Positive++
+	case analysis.LabelNegative:
+		s.summary.Negative++
+	case analysis.LabelNeutral:
+		s.summary.Neutral++
+	}
+}
+
+// flush returns the summary of the window and starts a new one
+func (s *wsSubscription) flush() db.ScoreSummary {
+	summary := s.summary
+	if scored := summary.Positive + summary.Negative + summary.Neutral; scored > 0 {
+		summary.MeanCompound = s.total / float64(scored)
+	}
+	s.summary, s.total = db.ScoreSummary{Scorer: s.scorer}, 0
+	return summary
+}
+
+// wsOutbox sits between a connection's event loop and its writer: replies and tweets are queued up to wsQueueSize,
+// the tweets that don't fit are dropped, and only the latest snapshot waits
+type wsOutbox struct {
+	queue    chan wsMessage
+	snapshot chan wsMessage
+	dropped  int64
+}
+
+func newWSOutbox(size int) *wsOutbox {
+	return &wsOutbox{queue: make(chan wsMessage, size), snapshot: make(chan wsMessage, 1)}
+}
+
+// send queues msg, dropping it when the client is behind (replies wait, they are rare and small)
+func (o *wsOutbox) send(ctx context.Context, msg wsMessage) {
+	if msg.Type != "tweet" {
+		select {
+		case o.queue <- msg:
+		case <-ctx.Done():
+		}
+		return
+	}
+	select {
+	case o.queue <- msg:
+	default:
+		atomic.AddInt64(&o.dropped, 1)
+	}
+}
+
+// sendSnapshot replaces the snapshot waiting to be written, if any. Only the event loop sends snapshots
+func (o *wsOutbox) sendSnapshot(msg wsMessage) {
+	select {
+	case <-o.snapshot:
+	default:
+	}
+	o.snapshot <- msg
+}
+
+// GET /ws
+// WebSocket live feed of the scored tweets matching the connection's subscriptions, with periodic snapshots
+func LiveFeed(hub *stream.Hub, opts LiveFeedOptions) gin.HandlerFunc {
+	upgrader := websocket.Upgrader{CheckOrigin: opts.checkOrigin}
+	return func(c *gin.Context) {
+		if hub == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "live tweets aren't available on this server"})
+			return
+		}
+		conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			// the upgrader already replied
+			return
+		}
+		defer conn.Close()
+		ctx, cancel := context.WithCancel(c.Request.Context())
+		defer cancel()
+
+		sub := hub.Subscribe(stream.DefaultBuffer)
+		defer sub.Close()
+		outbox := newWSOutbox(wsQueueSize)
+		commands := make(chan wsCommand)
+		go readCommands(ctx, cancel, conn, commands, outbox)
+		writerDone := make(chan struct{})
+		go func() {
+			defer close(writerDone)
+			defer cancel()
+			writeMessages(ctx, conn, outbox)
+		}()
+		liveFeedLoop(ctx, sub, commands, outbox, opts.SnapshotInterval)
+		cancel()
+		<-writerDone
+	}
+}
+
+// liveFeedLoop owns the connection's subscriptions: it applies the commands, matches tweets and takes snapshots
+func liveFeedLoop(ctx context.Context, sub *stream.Subscription, commands <-chan wsCommand, outbox *wsOutbox, snapshotEvery time.Duration) {
+	subscriptions := map[string]*wsSubscription{}
+	ticker := time.NewTicker(snapshotEvery)
+	defer ticker.Stop()
+	windowStart := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case cmd := <-commands:
+			outbox.send(ctx, applyCommand(subscriptions, cmd))
+		case msg, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			var matched []string
+			for id, s := range subscriptions {
+				if s.match(msg) {
+					s.add(msg)
+					matched = append(matched, id)
+				}
+			}
+			if len(matched) > 0 {
+				sort.Strings(matched)
+				msg := msg
+				outbox.send(ctx, wsMessage{Type: "tweet", Subscriptions: matched, Tweet: &msg})
+			}
+		case now := <-ticker.C:
+			snapshot := &wsSnapshot{
+				Time:      now.UTC(),
+				Window:    now.Sub(windowStart).Round(time.Millisecond).String(),
+				Summaries: make(map[string]db.ScoreSummary, len(subscriptions)),
+				Dropped:   atomic.LoadInt64(&outbox.dropped) + sub.Dropped(),
+			}
+			for id, s := range subscriptions {
+				snapshot.Summaries[id] = s.flush()
+			}
+			windowStart = now
+			outbox.sendSnapshot(wsMessage{Type: "snapshot", Snapshot: snapshot})
+		}
+	}
+}
+
+func applyCommand(subscriptions map[string]*wsSubscription, cmd wsCommand) wsMessage {
+	fail := func(format string, args ...interface{}) wsMessage {
+		return wsMessage{Type: "error", ID: cmd.ID, Error: fmt.Sprintf(format, args...)}
+	}
+	if cmd.ID == "" && cmd.Action != "" {
+		return fail("%s needs an id", cmd.Action)
+	}
+	switch cmd.Action {
+	case "subscribe":
+		if _, exists := subscriptions[cmd.ID]; !exists && len(subscriptions) >= wsMaxSubscriptions {
+			return fail("at most %d subscriptions per connection", wsMaxSubscriptions)
+		}
+		match, err := cmd.Filter.Matcher()
+		if err != nil {
+			return fail("%s", err)
+		}
+		scorer := cmd.Filter.Scorer
+		if scorer == "" {
+			scorer = "vader"
+		}
+		// subscribing again with the same id replaces the filter
+		subscriptions[cmd.ID] = &wsSubscription{match: match, scorer: scorer, summary: db.ScoreSummary{Scorer: scorer}}
+		return wsMessage{Type: "subscribed", ID: cmd.ID}
+	case "unsubscribe":
+		if _, exists := subscriptions[cmd.ID]; !exists {
+			return fail("no subscription %q", cmd.ID)
+		}
+		delete(subscriptions, cmd.ID)
+		return wsMessage{Type: "unsubscribed", ID: cmd.ID}
+	default:
+		return fail("unknown action %q (expected subscribe or unsubscribe)", cmd.Action)
+	}
+}
+
+// readCommands decodes the client's commands until the connection fails, the pongs keep it alive
+func readCommands(ctx context.Context, cancel context.CancelFunc, conn *websocket.Conn, commands chan<- wsCommand, outbox *wsOutbox) {
+	defer cancel()
+	conn.SetReadLimit(wsMaxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	})
+	for {
+		_, raw, err := conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				logger(ctx).Warn().Err(err).Msg("WebSocket closed unexpectedly")
+			}
+			return
+		}
+		var cmd wsCommand
+		if err := json.Unmarshal(raw, &cmd); err != nil {
+			outbox.send(ctx, wsMessage{Type: "error", Error: fmt.Sprintf("invalid command: %s", err)})
+			continue
+		}
+		select {
+		case commands <- cmd:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// writeMessages writes the outbox to the connection and pings the client
+func writeMessages(ctx context.Context, conn *websocket.Conn, outbox *wsOutbox) {
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	write := func(msg wsMessage) error {
+		conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		return conn.WriteJSON(msg)
+	}
+	for {
+		var err error
+		select {
+		case <-ctx.Done():
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+			return
+		case msg := <-outbox.queue:
+			err = write(msg)
+		case msg := <-outbox.snapshot:
+			err = write(msg)
+		case <-ping.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout))
+		}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dghubble/go-twitter/twitter"
+	"github.com/gorilla/websocket"
+	"github.com/jmoussa/go-sentitweet/analysis"
+	"github.com/jmoussa/go-sentitweet/config"
+	"github.com/jmoussa/go-sentitweet/db"
+	"github.com/jmoussa/go-sentitweet/stream"
+)
+
+func nextMessage(t *testing.T, conn *websocket.Conn, typ string) wsMessage {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var msg wsMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("waiting for a %s message: %s", typ, err)
+		}
+		if msg.Type == typ {
+			return msg
+		}
+	}
+}
+
+func TestLiveFeed(t *testing.T) {
+	hub := stream.NewHub()
+	r, err := NewRouter(db.NewMemoryStore(), hub, config.Config{General: map[string]string{"ws_snapshot_interval": "100ms"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(r)
+	defer server.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	send := func(cmd string) {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(cmd)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	send(`{"action": "subscribe", "id": "golang", "filter": {"term": "#golang"}}`)
+	if msg := nextMessage(t, conn, "subscribed"); msg.ID != "golang" {
+		t.Fatalf("unexpected acknowledgement %+v", msg)
+	}
+	send(`{"action": "subscribe", "id": "happy", "filter": {"minCompound": 0.1}}`)
+	nextMessage(t, conn, "subscribed")
+	send(`{"action": "subscribe", "id": "bad", "filter": {"label": "ecstatic"}}`)
+	if msg := nextMessage(t, conn, "error"); msg.ID != "bad" || msg.Error == "" {
+		t.Fatalf("expected the invalid filter to be rejected, got %+v", msg)
+	}
+
+	publish := func(id int64, text, term string) {
+		msg, err := analysis.ScoreTweet(analysis.VaderScorer{}, &twitter.Tweet{ID: id, Text: text})
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg.Term = term
+		hub.Publish(msg)
+	}
+	publish(1, "I hate this", "#rust")
+	publish(2, "I hate this", "#golang")
+	publish(3, "I love this", "#golang")
+	for _, want := range []struct {
+		id   int64
+		subs string
+	}{{2, "golang"}, {3, "golang,happy"}} {
+		msg := nextMessage(t, conn, "tweet")
+		if msg.Tweet.BaseTweet.ID != want.id || strings.Join(msg.Subscriptions, ",") != want.subs {
+			t.Fatalf("expected tweet %d for %s, got %d for %v", want.id, want.subs, msg.Tweet.BaseTweet.ID, msg.Subscriptions)
+		}
+	}
+	// the tweets may be split across snapshot windows, each window only counts its own
+	var golang, happy db.ScoreSummary
+	for golang.Count < 2 || happy.Count < 1 {
+		snapshot := nextMessage(t, conn, "snapshot").Snapshot
+		g, h := snapshot.Summaries["golang"], snapshot.Summaries["happy"]
+		golang.Count, golang.Positive, golang.Negative = golang.Count+g.Count, golang.Positive+g.Positive, golang.Negative+g.Negative
+		if h.Count > 0 {
+			happy = h
+		}
+	}
+	if golang.Count != 2 || golang.Positive != 1 || golang.Negative != 1 {
+		t.Fatalf("unexpected golang summaries %+v", golang)
+	}
+	if happy.Count != 1 || happy.MeanCompound <= 0 {
+		t.Fatalf("unexpected happy summary %+v", happy)
+	}
+	if snapshot := nextMessage(t, conn, "snapshot").Snapshot; snapshot.Summaries["golang"].Count != 0 {
+		t.Fatalf("expected the next window to start empty, got %+v", snapshot.Summaries["golang"])
+	}
+
+	send(`{"action": "unsubscribe", "id": "golang"}`)
+	nextMessage(t, conn, "unsubscribed")
+	send(`{"action": "unsubscribe", "id": "golang"}`)
+	nextMessage(t, conn, "error")
+	send(`not json`)
+	nextMessage(t, conn, "error")
+	publish(4, "I hate this", "#golang")
+	publish(5, "I love this", "#golang")
+	if msg := nextMessage(t, conn, "tweet"); msg.Tweet.BaseTweet.ID != 5 {
+		t.Fatalf("expected only tweet 5 to match after unsubscribing, got %d", msg.Tweet.BaseTweet.ID)
+	}
+
+	conn.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for hub.Subscribers() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the subscription outlived the client")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLiveFeedErrors(t *testing.T) {
+	if code, _ := doRequest(newTestRouter(t), http.MethodGet, "/ws", ""); code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 without a hub, got %d", code)
+	}
+	if _, err := NewRouter(db.NewMemoryStore(), nil, config.Config{General: map[string]string{"ws_snapshot_interval": "soon"}}); err == nil {
+		t.Error("expected an invalid ws_snapshot_interval to be rejected")
+	}
+
+	opts, err := liveFeedOptions(config.Config{General: map[string]string{"ws_allowed_origins": " https://dashboard.example.com ,"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for origin, allowed := range map[string]bool{
+		"":                              true,
+		"http://api.example.com":        true,
+		"https://dashboard.example.com": true,
+		"https://evil.example.com":      false,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "http://api.example.com/ws", nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		if opts.checkOrigin(req) != allowed {
+			t.Errorf("origin %q: expected allowed=%v", origin, allowed)
+		}
+	}
+}
+
+func TestWSOutbox(t *testing.T) {
+	ctx := context.Background()
+	outbox := newWSOutbox(2)
+	for i := 0; i < 5; i++ {
+		outbox.send(ctx, wsMessage{Type: "tweet"})
+	}
+	if len(outbox.queue) != 2 || outbox.dropped != 3 {
+		t.Fatalf("expected 2 queued and 3 dropped tweets, got %d and %d", len(outbox.queue), outbox.dropped)
+	}
+	outbox.sendSnapshot(wsMessage{Type: "snapshot", ID: "old"})
+	outbox.sendSnapshot(wsMessage{Type: "snapshot", ID: "new"})
+	if msg := <-outbox.snapshot; msg.ID != "new" || len(outbox.snapshot) != 0 {
+		t.Fatalf("expected the stale snapshot to be replaced, got %q", msg.ID)
+	}
+}
@@ -0,0 +1,204 @@
+/*
+Copyright © 2022 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/jmoussa/go-sentitweet/config"
+	"github.com/jmoussa/go-sentitweet/db"
+	"github.com/spf13/cobra"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// dbCmd groups the database maintenance commands
+var dbCmd = &cobra.Command{
+	Use:   "db",
+	Short: "Database maintenance",
+	Long:  `Maintenance tasks for the mongo database the pipeline writes to.`,
+}
+
+// backfillDatesCmd represents the db backfill-dates command
+var backfillDatesCmd = &cobra.Command{
+	Use:   "backfill-dates",
+	Short: "Set created_at/ingested_at on tweets stored before they existed",
+	Long: `Parses the tweet timestamp of documents that have no created_at date and stores it as a native date,
+	so daysBack queries find them. Safe to interrupt and re-run. Also applied by migration 1 of "tw db migrate up".`,
+	Run: func(cmd *cobra.Command, args []string) {
+		batchSize, _ := cmd.Flags().GetInt("batch-size")
+		ctx := context.Background()
+		store, err := db.NewMongoStore(ctx, config.ParseConfig())
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		defer store.Close(ctx)
+		updated, err := store.BackfillDates(ctx, batchSize)
+		fmt.Printf("Backfilled dates on %d tweets\n", updated)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+// migrateCmd groups the schema migration commands
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Apply or revert schema migrations of the tweets collection",
+	Long: `Versioned migrations of the stored documents, applied versions are recorded in the schema_migrations collection.
+	Migrations are idempotent: an interrupted one is resumed by running the command again.`,
+}
+
+var migrateUpCmd = &cobra.Command{
+	Use:   "up",
+	Short: "Apply pending migrations",
+	Run: func(cmd *cobra.Command, args []string) {
+		to, _ := cmd.Flags().GetInt("to")
+		withMigrator(func(ctx context.Context, migrator *db.Migrator) error {
+			return migrator.Up(ctx, to)
+		})
+	},
+}
+
+var migrateDownCmd = &cobra.Command{
+	Use:   "down",
+	Short: "Revert the latest migration, or every migration above --to",
+	Run: func(cmd *cobra.Command, args []string) {
+		to, _ := cmd.Flags().GetInt("to")
+		withMigrator(func(ctx context.Context, migrator *db.Migrator) error {
+			if !cmd.Flags().Changed("to") {
+				statuses, err := migrator.Status(ctx)
+				if err != nil {
+					return err
+				}
+				// one step back from the newest migration that isn't pending
+				to = 0
+				for i := len(statuses) - 1; i >= 0; i-- {
+					if statuses[i].State != db.MigrationPending {
+						to = statuses[i].Version - 1
+						break
+					}
+				}
+			}
+			return migrator.Down(ctx, to)
+		})
+	},
+}
+
+var migrateStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "List migrations and whether they are applied",
+	Run: func(cmd *cobra.Command, args []string) {
+		withMigrator(func(ctx context.Context, migrator *db.Migrator) error {
+			statuses, err := migrator.Status(ctx)
+			if err != nil {
+				return err
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "VERSION\tNAME\tSTATE\tUPDATED")
+			for _, status := range statuses {
+				updated := ""
+				if !status.UpdatedAt.IsZero() {
+					updated = status.UpdatedAt.Format("2006-01-02 15:04:05")
+				}
+				fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", status.Version, status.Name, status.State, updated)
+			}
+			return w.Flush()
+		})
+	},
+}
+
+// indexesCmd groups the index management commands
+var indexesCmd = &cobra.Command{
+	Use:   "indexes",
+	Short: "Manage the indexes of the tweets collection",
+	Long: `The server and pipeline ensure the indexes at startup (unless mongo_ensure_indexes is "false"),
+	on a large collection build them once with "tw db indexes ensure" before deploying.`,
+}
+
+var indexesEnsureCmd = &cobra.Command{
+	Use:   "ensure",
+	Short: "Create the missing indexes",
+	Run: func(cmd *cobra.Command, args []string) {
+		withTweetsCollection(func(ctx context.Context, tweets *mongo.Collection) error {
+			// the indexes that could be built are, even when others fail
+			names, err := db.EnsureIndexes(ctx, tweets)
+			if len(names) > 0 {
+				fmt.Println("Ensured indexes:", strings.Join(names, ", "))
+			}
+			return err
+		})
+	},
+}
+
+var indexesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the indexes of the tweets collection",
+	Run: func(cmd *cobra.Command, args []string) {
+		withTweetsCollection(func(ctx context.Context, tweets *mongo.Collection) error {
+			indexes, err := db.ListIndexes(ctx, tweets)
+			if err != nil {
+				return err
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "NAME\tKEY\tUNIQUE")
+			for _, index := range indexes {
+				unique, _ := index["unique"].(bool)
+				fmt.Fprintf(w, "%v\t%v\t%t\n", index["name"], index["key"], unique)
+			}
+			return w.Flush()
+		})
+	},
+}
+
+var indexesDropCmd = &cobra.Command{
+	Use:   "drop [name...]",
+	Short: "Drop the named indexes, or every index managed by tw",
+	Run: func(cmd *cobra.Command, args []string) {
+		withTweetsCollection(func(ctx context.Context, tweets *mongo.Collection) error {
+			return db.DropIndexes(ctx, tweets, args...)
+		})
+	},
+}
+
+func withMigrator(fn func(ctx context.Context, migrator *db.Migrator) error) {
+	withTweetsCollection(func(ctx context.Context, tweets *mongo.Collection) error {
+		return fn(ctx, db.NewMigrator(tweets))
+	})
+}
+
+func withTweetsCollection(fn func(ctx context.Context, tweets *mongo.Collection) error) {
+	ctx := context.Background()
+	cfg := config.ParseConfig()
+	client, err := db.OpenMongoClientWithConfig(ctx, cfg)
+	if err != nil {
+		fmt.Println("Could not connect to mongo:", err)
+		os.Exit(1)
+	}
+	err = fn(ctx, db.TweetsCollection(client, cfg))
+	db.CloseMongoClient(client, ctx)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(dbCmd)
+	dbCmd.AddCommand(backfillDatesCmd)
+	dbCmd.AddCommand(migrateCmd)
+	migrateCmd.AddCommand(migrateUpCmd, migrateDownCmd, migrateStatusCmd)
+	dbCmd.AddCommand(indexesCmd)
+	indexesCmd.AddCommand(indexesEnsureCmd, indexesListCmd, indexesDropCmd)
+
+	backfillDatesCmd.Flags().Int("batch-size", 1000, "Number of tweets updated per bulk write")
+	migrateUpCmd.Flags().Int("to", 0, "Only apply migrations up to this version (default: all)")
+	migrateDownCmd.Flags().Int("to", 0, "Revert every migration above this version (default: only the latest)")
+}
@@ -0,0 +1,193 @@
+/*
+Copyright © 2022 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jmoussa/go-sentitweet/api"
+	"github.com/jmoussa/go-sentitweet/config"
+	"github.com/jmoussa/go-sentitweet/db"
+	"github.com/jmoussa/go-sentitweet/export"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// queryCmd represents the query command
+var queryCmd = &cobra.Command{
+	Use:   "query",
+	Short: "Export the stored tweets matching a filter as CSV, JSON, NDJSON or Parquet",
+	Long: `Queries the configured storage backend, or a running API server with --api-url, with the filters of POST /tweets
+	and streams every matching tweet, page by page, to stdout or to a file in --output-path.
+
+	tw query --days-back=5 --output=csv --output-path=./output/
+	tw query --api-url=http://localhost:8080 --term="#amazon" --label=negative --output=ndjson`,
+	// the errors are the export's, not a misuse of the flags
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		body, err := queryBody(cmd.Flags())
+		if err != nil {
+			return err
+		}
+		format, _ := cmd.Flags().GetString("output")
+		outputPath, _ := cmd.Flags().GetString("output-path")
+		apiURL, _ := cmd.Flags().GetString("api-url")
+		limit, _ := cmd.Flags().GetInt("limit")
+
+		// Ctrl-C stops after the page being written, an export file is then discarded
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		var fetch export.PageFunc
+		if apiURL != "" {
+			client := api.NewClient(apiURL)
+			fetch = func(ctx context.Context, cursor string) (db.TweetPage, error) {
+				page := body
+				page.Cursor = cursor
+				return client.FindTweets(ctx, page)
+			}
+		} else {
+			store, err := db.OpenStore(ctx, config.ParseConfig())
+			if err != nil {
+				return fmt.Errorf("could not open storage: %w", err)
+			}
+			defer store.Close(context.Background())
+			fetch = func(ctx context.Context, cursor string) (db.TweetPage, error) {
+				return store.Find(ctx, db.TweetQuery{TweetFilter: body.TweetFilter, Sort: body.Sort, Limit: body.Limit, Cursor: cursor})
+			}
+		}
+
+		if outputPath == "" {
+			_, err := exportTweets(ctx, os.Stdout, format, fetch, limit)
+			return err
+		}
+		return exportFile(ctx, outputPath, format, fetch, limit)
+	},
+}
+
+// queryBody reads the filter, sort and page size flags
+func queryBody(flags *pflag.FlagSet) (api.TweetSearchBody, error) {
+	var body api.TweetSearchBody
+	body.SearchPhrase, _ = flags.GetString("search")
+	body.DaysBack, _ = flags.GetInt("days-back")
+	body.Scorer, _ = flags.GetString("scorer")
+	body.Label, _ = flags.GetString("label")
+	body.Author, _ = flags.GetString("author")
+	body.Hashtags, _ = flags.GetStringSlice("hashtag")
+	body.Lang, _ = flags.GetString("lang")
+	body.MinFollowers, _ = flags.GetInt("min-followers")
+	body.Term, _ = flags.GetString("term")
+	body.Sort, _ = flags.GetString("sort")
+	body.Limit, _ = flags.GetInt("page-size")
+	for name, bound := range map[string]*time.Time{"from": &body.From, "to": &body.To} {
+		raw, _ := flags.GetString(name)
+		if raw == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return body, fmt.Errorf("invalid --%s %q, expected an RFC 3339 date", name, raw)
+		}
+		*bound = t
+	}
+	// the optional conditions are only set when their flag is given
+	for name, bound := range map[string]**float64{"min-compound": &body.MinCompound, "max-compound": &body.MaxCompound} {
+		if flags.Changed(name) {
+			value, _ := flags.GetFloat64(name)
+			*bound = &value
+		}
+	}
+	for name, kind := range map[string]**bool{"is-retweet": &body.IsRetweet, "is-quote": &body.IsQuote, "is-reply": &body.IsReply} {
+		if flags.Changed(name) {
+			value, _ := flags.GetBool(name)
+			*kind = &value
+		}
+	}
+	return body, nil
+}
+
+// exportTweets writes the tweets fetch returns to out in format and returns how many were written
+func exportTweets(ctx context.Context, out io.Writer, format string, fetch export.PageFunc, limit int) (int, error) {
+	w, err := export.NewWriter(out, format)
+	if err != nil {
+		return 0, err
+	}
+	written, err := export.Copy(ctx, w, fetch, limit)
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	return written, err
+}
+
+// exportFile writes the export to a new timestamped file in dir, under a hidden .tmp name renamed once complete
+// like the file sink's chunks, so dir never holds a partial export
+func exportFile(ctx context.Context, dir, format string, fetch export.PageFunc, limit int) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	name := filepath.Join(dir, fmt.Sprintf("tweets-%s.%s", time.Now().UTC().Format("20060102T150405Z"), format))
+	tmp := filepath.Join(dir, "."+filepath.Base(name)+".tmp")
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	written, err := exportTweets(ctx, f, format, fetch, limit)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, name)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("could not export to %s: %w", name, err)
+	}
+	fmt.Fprintf(os.Stderr, "%d tweets written to %s\n", written, name)
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(queryCmd)
+
+	flags := queryCmd.Flags()
+	// --days_back and --days-back are the same flag
+	flags.SetNormalizeFunc(func(f *pflag.FlagSet, name string) pflag.NormalizedName {
+		return pflag.NormalizedName(strings.ReplaceAll(name, "_", "-"))
+	})
+	flags.String("api-url", "", "Query a running API server (e.g. http://localhost:8080) instead of the storage backend from config.json")
+	flags.String("output", export.FormatCSV, "Output format: csv, json, ndjson or parquet")
+	flags.String("output-path", "", "Directory to write a timestamped export file to (default: stdout)")
+	flags.Int("limit", 0, "Maximum number of tweets to export (default: every match)")
+	flags.Int("page-size", db.MaxPageSize, "Tweets fetched per page")
+	flags.String("sort", db.SortDate, "date (newest first) or compound (highest --scorer compound score first)")
+
+	flags.String("search", "", "Text the tweets must contain")
+	flags.Int("days-back", 0, "Only tweets created in the last days")
+	flags.String("from", "", "Only tweets created at or after this RFC 3339 date")
+	flags.String("to", "", "Only tweets created before this RFC 3339 date")
+	flags.String("scorer", "", "Scorer of the compound/label conditions and of --sort=compound (default: vader)")
+	flags.Float64("min-compound", 0, "Minimum compound score")
+	flags.Float64("max-compound", 0, "Maximum compound score")
+	flags.String("label", "", "Sentiment label: positive, negative or neutral")
+	flags.String("author", "", "Screen name of the author")
+	flags.StringSlice("hashtag", nil, "Hashtags the tweets must all use")
+	flags.String("lang", "", "Tweet language code")
+	flags.Bool("is-retweet", false, "Only retweets (true) or no retweets (false)")
+	flags.Bool("is-quote", false, "Only quotes (true) or no quotes (false)")
+	flags.Bool("is-reply", false, "Only replies (true) or no replies (false)")
+	flags.Int("min-followers", 0, "Minimum follower count of the author")
+	flags.String("term", "", "Tracked term the tweets were collected for")
+}
@@ -6,8 +6,11 @@ package cmd
 
 import (
 	"fmt"
+	"os"
 
+	"github.com/jmoussa/go-sentitweet/api"
 	data_pipelines "github.com/jmoussa/go-sentitweet/data-pipelines"
+	"github.com/jmoussa/go-sentitweet/stream"
 	"github.com/spf13/cobra"
 )
 
@@ -19,8 +22,41 @@ var runSentimentAnalysisCmd = &cobra.Command{
 	Runs in foreground.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		searchTerm, _ := cmd.Flags().GetString("term")
-		fmt.Println("Sentiment Analysis Pipeline Starting for: ", searchTerm)
-		data_pipelines.RunTwitterPipeline(searchTerm)
+		source, _ := cmd.Flags().GetString("source")
+		var opts []data_pipelines.Option
+		if output, _ := cmd.Flags().GetString("output"); output != "" {
+			chunkSize, _ := cmd.Flags().GetInt("chunk-size")
+			outputPath, _ := cmd.Flags().GetString("output-path")
+			outputOnly, _ := cmd.Flags().GetBool("output-only")
+			opts = append(opts, data_pipelines.WithFileOutput(output, chunkSize, outputPath, outputOnly))
+		}
+		if server, _ := cmd.Flags().GetBool("server"); server {
+			// the API's /stream gets the tweets straight from the pipeline
+			hub := stream.NewHub()
+			go api.RunServerWithHub(hub)
+			opts = append(opts, data_pipelines.WithPublisher(hub.Publish))
+		}
+		switch source {
+		case "":
+			// no explicit source: use the source stage from config.json (if any), --term overrides its term
+			fmt.Println("Sentiment Analysis Pipeline Starting from configured stages")
+			data_pipelines.RunConfiguredPipeline(searchTerm, opts...)
+		case "twitter":
+			fmt.Println("Sentiment Analysis Pipeline Starting for: ", searchTerm)
+			data_pipelines.RunTwitterPipeline(searchTerm, opts...)
+		case "file":
+			input, _ := cmd.Flags().GetString("input")
+			rate, _ := cmd.Flags().GetFloat64("rate")
+			if input == "" {
+				fmt.Println("--input is required with --source=file")
+				os.Exit(1)
+			}
+			fmt.Println("Sentiment Analysis Pipeline Replaying: ", input)
+			data_pipelines.RunPipeline(data_pipelines.NewFileSource(input, rate), opts...)
+		default:
+			fmt.Printf("Unknown source %q (expected twitter or file)\n", source)
+			os.Exit(1)
+		}
 	},
 }
 
@@ -32,6 +68,14 @@ func init() {
 	// Cobra supports Persistent Flags which will work for this command
 	// and all subcommands, e.g.:
 	runSentimentAnalysisCmd.PersistentFlags().String("term", "", "Search term to filter tweets for the pipeline (default:'#nft'")
+	runSentimentAnalysisCmd.PersistentFlags().String("source", "", "Where tweets come from: twitter (live filter stream) or file (JSONL/NDJSON replay) (default: source stage from config, else twitter)")
+	runSentimentAnalysisCmd.PersistentFlags().String("input", "", "Path of the JSONL/NDJSON file (optionally gzipped) to replay with --source=file")
+	runSentimentAnalysisCmd.PersistentFlags().Float64("rate", 0, "Replay speed for --source=file: 0 as fast as possible, 1 at original tweet timestamps, 2 twice as fast, ...")
+	runSentimentAnalysisCmd.PersistentFlags().String("output", "", "Also write the scored tweets to chunk files: csv, ndjson or parquet")
+	runSentimentAnalysisCmd.PersistentFlags().Int("chunk-size", 100, "Tweets per chunk file with --output")
+	runSentimentAnalysisCmd.PersistentFlags().String("output-path", "./output/", "Directory of the chunk files and their manifest.json with --output")
+	runSentimentAnalysisCmd.PersistentFlags().Bool("server", false, "Also run the API server (on port 8080), streaming the pipeline's tweets live on /stream")
+	runSentimentAnalysisCmd.PersistentFlags().Bool("output-only", false, "With --output, write the chunk files instead of uploading to the database")
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	// runSentimentAnalysisCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
@@ -0,0 +1,113 @@
+/*
+Copyright © 2022 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/jmoussa/go-sentitweet/analysis"
+	"github.com/jmoussa/go-sentitweet/export"
+	"github.com/spf13/cobra"
+)
+
+// scoreCmd represents the score command
+var scoreCmd = &cobra.Command{
+	Use:   "score [text...]",
+	Short: "Score text with the sentiment scorers",
+	Long: `Scores each argument, or each line of the --file inputs or of stdin when there are no arguments,
+	with the analysis scorers. No database or Twitter connection is needed.
+
+	tw score "I love this"
+	cat reviews.txt | tw score --scorer=vader,imdb --format=csv`,
+	Run: func(cmd *cobra.Command, args []string) {
+		scorerNames, _ := cmd.Flags().GetStringSlice("scorer")
+		format, _ := cmd.Flags().GetString("format")
+		files, _ := cmd.Flags().GetStringSlice("file")
+
+		scorers := make([]analysis.Scorer, 0, len(scorerNames))
+		for _, name := range scorerNames {
+			scorer, err := analysis.GetScorer(strings.TrimSpace(name))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s (available: %s)\n", err, strings.Join(analysis.ScorerNames(), ", "))
+				os.Exit(1)
+			}
+			scorers = append(scorers, scorer)
+		}
+		out, err := export.NewScoreWriter(os.Stdout, format, scorers)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		score := func(text string) error {
+			results, err := analysis.ScoreAll(context.Background(), []string{text}, scorers)
+			if err != nil {
+				return err
+			}
+			return out.Write(text, results[0])
+		}
+		switch {
+		case len(args) > 0:
+			for _, text := range args {
+				if err = score(text); err != nil {
+					break
+				}
+			}
+		case len(files) > 0:
+			for _, path := range files {
+				if err = scoreFile(path, score); err != nil {
+					break
+				}
+			}
+		default:
+			err = scoreLines(os.Stdin, score)
+		}
+		if flushErr := out.Flush(); err == nil {
+			err = flushErr
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+func scoreFile(path string, score func(string) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return scoreLines(f, score)
+}
+
+// scoreLines scores every non blank line of r as it is read
+func scoreLines(r io.Reader, score func(string) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if err := score(line); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func init() {
+	rootCmd.AddCommand(scoreCmd)
+
+	scoreCmd.Flags().StringSlice("scorer", []string{"vader"}, "Comma separated scorers to run (vader, imdb)")
+	scoreCmd.Flags().String("format", "table", "Output format: json, csv or table")
+	scoreCmd.Flags().StringSlice("file", nil, "Score each line of these files instead of stdin")
+}
@@ -0,0 +1,419 @@
+package data_pipelines
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoussa/go-sentitweet/config"
+	"github.com/jmoussa/go-sentitweet/monitoring"
+)
+
+/*
+Declarative pipeline topology.
+The `stages` section of config.json describes a DAG of stages:
+
+	{"name": "lexicon", "function": "lexicon_sentiment_analysis", "concurrency": "8"},
+	{"name": "upload", "type": "sink", "function": "format_and_upload", "depends_on": "lexicon"}
+
+  - name:        unique stage name, referenced by depends_on
+  - type:        source, step (default), join or sink
+  - function:    registered step (or source) to run, joins don't have one
+  - depends_on:  comma separated upstream stages (default: the source)
+  - concurrency: max concurrent messages for the stage (default: the step's preference, else CPU count)
+  - timeout:     how long a join waits for all its upstreams, e.g. "5s" (default: 10s)
+
+Stages sharing an upstream run in parallel on copies of its output, stages with several
+upstreams get their inputs merged (or joined by tweet ID for join stages), and every stage
+nothing depends on feeds the final sink.
+*/
+
+const (
+	stageTypeSource = "source"
+	stageTypeStep   = "step"
+	stageTypeJoin   = "join"
+	stageTypeSink   = "sink"
+
+	// name of the implicit source when the config doesn't declare one
+	defaultSourceName = "source"
+)
+
+// DefaultStages is the topology used when config.json has no stages
+var DefaultStages = []map[string]string{
+	{"name": "lexicon", "function": "lexicon_sentiment_analysis"},
+	{"name": "imdb", "function": "imdb_sentiment_analysis"},
+	{"name": "merge", "type": stageTypeJoin, "depends_on": "lexicon,imdb"},
+	{"name": "upload", "type": stageTypeSink, "function": "format_and_upload", "depends_on": "merge"},
+}
+
+type stage struct {
+	Name        string
+	Type        string
+	Function    string
+	DependsOn   []string
+	Concurrency int
+	Timeout     time.Duration
+	Params      map[string]string
+	// factory builds the stages added by options (WithPublisher, ...) instead of the registered Function
+	factory StepFactory
+}
+
+// Pipeline is a validated, topologically sorted set of stages
+type Pipeline struct {
+	// Source is the declared source stage, nil when the caller provides the Source
+	Source *stage
+	// Stages are ordered so every stage comes after the stages it depends on
+	Stages []*stage
+	// Config is handed to the step factories (mongo settings, ...)
+	Config config.Config
+	// DrainTimeout bounds how long in-flight tweets may take to drain on shutdown (default: 30s)
+	DrainTimeout time.Duration
+	// Term is the search term of the source, handed to stages that don't set their own "term"
+	Term string
+	// Logger receives the stages' trace entries, Run builds it from the config when nil
+	Logger *monitoring.Logger
+}
+
+const defaultDrainTimeout = 30 * time.Second
+
+func (p *Pipeline) drainTimeout() time.Duration {
+	if p.DrainTimeout <= 0 {
+		return defaultDrainTimeout
+	}
+	return p.DrainTimeout
+}
+
+// BuildPipeline validates the stages config and sorts it into a runnable DAG
+func BuildPipeline(stages []map[string]string) (*Pipeline, error) {
+	if len(stages) == 0 {
+		stages = DefaultStages
+	}
+	p := &Pipeline{}
+	byName := map[string]*stage{}
+	var ordered []*stage
+	for i, raw := range stages {
+		st, err := parseStage(i, raw)
+		if err != nil {
+			return nil, err
+		}
+		if _, exists := byName[st.Name]; exists {
+			return nil, fmt.Errorf("stage %q is declared more than once", st.Name)
+		}
+		byName[st.Name] = st
+		if st.Type == stageTypeSource {
+			if p.Source != nil {
+				return nil, fmt.Errorf("stage %q: only one source stage is supported, %q is already declared", st.Name, p.Source.Name)
+			}
+			p.Source = st
+			continue
+		}
+		ordered = append(ordered, st)
+	}
+	if len(ordered) == 0 {
+		return nil, fmt.Errorf("pipeline has no steps")
+	}
+
+	sourceName := defaultSourceName
+	if p.Source != nil {
+		sourceName = p.Source.Name
+	} else if _, exists := byName[defaultSourceName]; exists {
+		return nil, fmt.Errorf("stage name %q is reserved for the implicit source", defaultSourceName)
+	}
+
+	// resolve dependencies and count incoming edges for the topological sort
+	indegree := map[string]int{}
+	dependents := map[string][]*stage{}
+	for _, st := range ordered {
+		if len(st.DependsOn) == 0 {
+			st.DependsOn = []string{sourceName}
+		}
+		for _, dep := range st.DependsOn {
+			if dep == st.Name {
+				return nil, fmt.Errorf("stage %q depends on itself", st.Name)
+			}
+			if dep == sourceName {
+				continue
+			}
+			upstream, exists := byName[dep]
+			if !exists {
+				return nil, fmt.Errorf("stage %q depends on unknown stage %q", st.Name, dep)
+			}
+			if upstream.Type == stageTypeSink {
+				return nil, fmt.Errorf("stage %q depends on sink %q, sinks can't have downstream stages", st.Name, dep)
+			}
+			indegree[st.Name]++
+			dependents[dep] = append(dependents[dep], st)
+		}
+	}
+
+	// Kahn's algorithm, keeping config order among stages that are ready at the same time
+	var queue []*stage
+	for _, st := range ordered {
+		if indegree[st.Name] == 0 {
+			queue = append(queue, st)
+		}
+	}
+	for len(queue) > 0 {
+		st := queue[0]
+		queue = queue[1:]
+		p.Stages = append(p.Stages, st)
+		for _, next := range dependents[st.Name] {
+			indegree[next.Name]--
+			if indegree[next.Name] == 0 {
+				queue = append(queue, next)
+			}
+		}
+	}
+	if len(p.Stages) != len(ordered) {
+		var cyclic []string
+		for _, st := range ordered {
+			if indegree[st.Name] > 0 {
+				cyclic = append(cyclic, st.Name)
+			}
+		}
+		return nil, fmt.Errorf("stages form a cycle: %s", strings.Join(cyclic, ", "))
+	}
+	return p, nil
+}
+
+func parseStage(i int, raw map[string]string) (*stage, error) {
+	st := &stage{
+		Name:     strings.TrimSpace(raw["name"]),
+		Type:     strings.TrimSpace(raw["type"]),
+		Function: strings.TrimSpace(raw["function"]),
+		Timeout:  defaultJoinTimeout,
+		Params:   raw,
+	}
+	if st.Name == "" {
+		return nil, fmt.Errorf("stage #%d has no name", i+1)
+	}
+	if st.Type == "" {
+		st.Type = stageTypeStep
+	}
+	switch st.Type {
+	case stageTypeSource:
+		if _, ok := sourceRegistry[st.Function]; !ok {
+			return nil, fmt.Errorf("stage %q: unknown source %q", st.Name, st.Function)
+		}
+		if raw["depends_on"] != "" {
+			return nil, fmt.Errorf("stage %q: a source can't depend on other stages", st.Name)
+		}
+		return st, nil
+	case stageTypeStep, stageTypeSink:
+		if _, ok := stepRegistry[st.Function]; !ok {
+			return nil, fmt.Errorf("stage %q: unknown function %q (available: %s)", st.Name, st.Function, strings.Join(StepNames(), ", "))
+		}
+	case stageTypeJoin:
+		if st.Function != "" {
+			return nil, fmt.Errorf("stage %q: joins don't run a function", st.Name)
+		}
+		if raw["timeout"] != "" {
+			timeout, err := time.ParseDuration(raw["timeout"])
+			if err != nil || timeout <= 0 {
+				return nil, fmt.Errorf("stage %q: timeout must be a positive duration, got %q", st.Name, raw["timeout"])
+			}
+			st.Timeout = timeout
+		}
+	default:
+		return nil, fmt.Errorf("stage %q: unknown type %q", st.Name, st.Type)
+	}
+	for _, dep := range strings.Split(raw["depends_on"], ",") {
+		if dep = strings.TrimSpace(dep); dep != "" {
+			st.DependsOn = append(st.DependsOn, dep)
+		}
+	}
+	if raw["concurrency"] != "" {
+		n, err := strconv.Atoi(raw["concurrency"])
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("stage %q: concurrency must be a positive integer, got %q", st.Name, raw["concurrency"])
+		}
+		st.Concurrency = n
+	}
+	return st, nil
+}
+
+// setTerm overrides the stage's "term" param, leaving the parsed config untouched
+func (st *stage) setTerm(term string) {
+	params := make(map[string]string, len(st.Params)+1)
+	for k, v := range st.Params {
+		params[k] = v
+	}
+	params["term"] = term
+	st.Params = params
+}
+
+// NewSource builds the declared source stage
+func (p *Pipeline) NewSource(cfg config.Config) (Source, error) {
+	if p.Source == nil {
+		return nil, fmt.Errorf("pipeline has no source stage")
+	}
+	return sourceRegistry[p.Source.Function](p.Source.Params, cfg)
+}
+
+// start wires every stage between the source channel and the returned sink channel.
+// The returned steps must be closed once the sink channel is drained.
+func (p *Pipeline) start(ctx context.Context, sourceChannel <-chan interface{}, errorChannel chan error) (<-chan interface{}, []Step, error) {
+	steps := make([]Step, 0, len(p.Stages))
+	fns := make(map[string]StepFunc, len(p.Stages))
+	for _, st := range p.Stages {
+		if st.Type == stageTypeJoin {
+			continue
+		}
+		factory := stepRegistry[st.Function]
+		if st.factory != nil {
+			factory = st.factory
+		}
+		instance, err := factory(p.stageParams(st), p.Config)
+		if err != nil {
+			closeSteps(steps)
+			return nil, nil, fmt.Errorf("stage %q: %w", st.Name, err)
+		}
+		steps = append(steps, instance)
+		fns[st.Name] = instance.Fn
+		if st.Concurrency == 0 {
+			st.Concurrency = instance.Concurrency
+		}
+		if st.Concurrency == 0 {
+			st.Concurrency = runtime.NumCPU()
+		}
+	}
+
+	// every stage gets its own copy of each upstream's output
+	consumers := map[string]int{}
+	for _, st := range p.Stages {
+		for _, dep := range st.DependsOn {
+			consumers[dep]++
+		}
+	}
+	copies := map[string][]<-chan interface{}{}
+	fanOut := func(name string, c <-chan interface{}) {
+		copies[name] = broadcast(ctx, c, consumers[name])
+	}
+	sourceName := defaultSourceName
+	if p.Source != nil {
+		sourceName = p.Source.Name
+	}
+	fanOut(sourceName, sourceChannel)
+
+	var leaves []<-chan interface{}
+	for _, st := range p.Stages {
+		inputs := make([]<-chan interface{}, 0, len(st.DependsOn))
+		for _, dep := range st.DependsOn {
+			inputs = append(inputs, copies[dep][0])
+			copies[dep] = copies[dep][1:]
+		}
+		input := inputs[0]
+		if len(inputs) > 1 {
+			input = mergeAtomic(make(chan interface{}), inputs...)
+		}
+
+		output := make(chan interface{})
+		if st.Type == stageTypeJoin {
+			go join(ctx, input, output, errorChannel, len(inputs), st.Timeout, st.Name)
+		} else {
+			go step[interface{}, interface{}](ctx, input, output, errorChannel, fns[st.Name], st.Concurrency, st.Name, p.Logger)
+		}
+
+		if consumers[st.Name] == 0 {
+			leaves = append(leaves, output)
+		} else {
+			fanOut(st.Name, output)
+		}
+	}
+
+	if len(leaves) == 1 {
+		return leaves[0], steps, nil
+	}
+	return mergeAtomic(make(chan interface{}), leaves...), steps, nil
+}
+
+func (p *Pipeline) stageParams(st *stage) map[string]string {
+	if p.Term == "" || st.Params["term"] != "" {
+		return st.Params
+	}
+	params := make(map[string]string, len(st.Params)+1)
+	for k, v := range st.Params {
+		params[k] = v
+	}
+	params["term"] = p.Term
+	return params
+}
+
+// sinkUpstreams returns the sinks running function (every sink when empty) and the stages they depend on
+func (p *Pipeline) sinkUpstreams(function string) ([]*stage, []string) {
+	var (
+		sinks     []*stage
+		dependsOn []string
+		seen      = map[string]bool{}
+	)
+	for _, st := range p.Stages {
+		if st.Type != stageTypeSink || (function != "" && st.Function != function) {
+			continue
+		}
+		sinks = append(sinks, st)
+		for _, dep := range st.DependsOn {
+			if !seen[dep] {
+				seen[dep] = true
+				dependsOn = append(dependsOn, dep)
+			}
+		}
+	}
+	return sinks, dependsOn
+}
+
+// hasStage tells whether a stage is named name
+func (p *Pipeline) hasStage(name string) bool {
+	for _, st := range p.Stages {
+		if st.Name == name {
+			return true
+		}
+	}
+	return p.Source != nil && p.Source.Name == name
+}
+
+// closeSteps releases the resources held by steps (db clients, ...)
+func closeSteps(steps []Step) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, st := range steps {
+		if st.Close == nil {
+			continue
+		}
+		if err := st.Close(ctx); err != nil {
+			logger().Error().Err(err).Msg("Could not close a pipeline step")
+		}
+	}
+}
+
+// broadcast copies every value of in to n output channels
+func broadcast(ctx context.Context, in <-chan interface{}, n int) []<-chan interface{} {
+	if n == 1 {
+		return []<-chan interface{}{in}
+	}
+	outs := make([]chan interface{}, n)
+	result := make([]<-chan interface{}, n)
+	for i := range outs {
+		outs[i] = make(chan interface{})
+		result[i] = outs[i]
+	}
+	go func() {
+		defer func() {
+			for _, out := range outs {
+				close(out)
+			}
+		}()
+		for v := range in {
+			for _, out := range outs {
+				select {
+				case out <- v:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return result
+}
@@ -0,0 +1,141 @@
+package data_pipelines
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jmoussa/go-sentitweet/config"
+)
+
+func stageNames(p *Pipeline) []string {
+	var names []string
+	for _, st := range p.Stages {
+		names = append(names, st.Name)
+	}
+	return names
+}
+
+func TestBuildPipelineDefaults(t *testing.T) {
+	p, err := BuildPipeline(nil)
+	if err != nil {
+		t.Fatalf("BuildPipeline: %v", err)
+	}
+	if got := strings.Join(stageNames(p), ","); got != "lexicon,imdb,merge,upload" {
+		t.Errorf("unexpected default stages %s", got)
+	}
+	if p.Source != nil {
+		t.Errorf("default pipeline shouldn't declare a source")
+	}
+}
+
+func TestBuildPipelineSortsStages(t *testing.T) {
+	p, err := BuildPipeline([]map[string]string{
+		{"name": "upload", "type": "sink", "function": "format_and_upload", "depends_on": "imdb, lexicon"},
+		{"name": "imdb", "function": "imdb_sentiment_analysis", "depends_on": "src"},
+		{"name": "src", "type": "source", "function": "file", "input": "tweets.jsonl"},
+		{"name": "lexicon", "function": "lexicon_sentiment_analysis", "concurrency": "3"},
+	})
+	if err != nil {
+		t.Fatalf("BuildPipeline: %v", err)
+	}
+	if got := strings.Join(stageNames(p), ","); got != "imdb,lexicon,upload" {
+		t.Errorf("unexpected stage order %s", got)
+	}
+	if p.Stages[1].Concurrency != 3 || p.Stages[1].DependsOn[0] != "src" {
+		t.Errorf("unexpected lexicon stage %+v", p.Stages[1])
+	}
+}
+
+func TestBuildPipelineValidation(t *testing.T) {
+	cases := map[string]struct {
+		stages []map[string]string
+		err    string
+	}{
+		"unknown function": {
+			[]map[string]string{{"name": "a", "function": "nope"}},
+			`unknown function "nope"`,
+		},
+		"unknown dependency": {
+			[]map[string]string{{"name": "a", "function": "format_and_upload", "depends_on": "b"}},
+			`unknown stage "b"`,
+		},
+		"cycle": {
+			[]map[string]string{
+				{"name": "a", "function": "lexicon_sentiment_analysis"},
+				{"name": "b", "function": "lexicon_sentiment_analysis", "depends_on": "a,c"},
+				{"name": "c", "function": "lexicon_sentiment_analysis", "depends_on": "b"},
+			},
+			"cycle: b, c",
+		},
+		"downstream of sink": {
+			[]map[string]string{
+				{"name": "a", "type": "sink", "function": "format_and_upload"},
+				{"name": "b", "function": "lexicon_sentiment_analysis", "depends_on": "a"},
+			},
+			"sinks can't have downstream stages",
+		},
+		"duplicate": {
+			[]map[string]string{
+				{"name": "a", "function": "format_and_upload"},
+				{"name": "a", "function": "format_and_upload"},
+			},
+			"declared more than once",
+		},
+		"join with a function": {
+			[]map[string]string{{"name": "a", "type": "join", "function": "format_and_upload"}},
+			"joins don't run a function",
+		},
+		"bad concurrency": {
+			[]map[string]string{{"name": "a", "function": "format_and_upload", "concurrency": "0"}},
+			"concurrency must be a positive integer",
+		},
+	}
+	for name, c := range cases {
+		_, err := BuildPipeline(c.stages)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%s: expected error containing %q, got %v", name, c.err, err)
+		}
+	}
+}
+
+func TestStageParamsTerm(t *testing.T) {
+	p, err := BuildPipeline([]map[string]string{
+		{"name": "upload", "type": "sink", "function": "format_and_upload"},
+		{"name": "archive", "type": "sink", "function": "format_and_upload", "term": "#archived"},
+	})
+	if err != nil {
+		t.Fatalf("BuildPipeline: %v", err)
+	}
+	p.Term = "#golang"
+	if got := p.stageParams(p.Stages[0])["term"]; got != "#golang" {
+		t.Errorf("expected the source term, got %q", got)
+	}
+	if got := p.stageParams(p.Stages[1])["term"]; got != "#archived" {
+		t.Errorf("expected the stage's own term, got %q", got)
+	}
+	if _, ok := p.Stages[0].Params["term"]; ok {
+		t.Error("stage config shouldn't be modified")
+	}
+}
+
+func TestSourceTermOverride(t *testing.T) {
+	raw := []map[string]string{
+		{"name": "stream", "type": "source", "function": "twitter", "term": "#nft"},
+		{"name": "upload", "type": "sink", "function": "format_and_upload"},
+	}
+	p, err := BuildPipeline(raw)
+	if err != nil {
+		t.Fatalf("BuildPipeline: %v", err)
+	}
+	p.Source.setTerm("#golang")
+	src, err := p.NewSource(config.Config{})
+	if err != nil {
+		t.Fatalf("NewSource: %v", err)
+	}
+	if got := src.(termSource).Term(); got != "#golang" {
+		t.Errorf("expected the overridden term, got %q", got)
+	}
+	if raw[0]["term"] != "#nft" {
+		t.Error("stage config shouldn't be modified")
+	}
+}
@@ -0,0 +1,267 @@
+package data_pipelines
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jmoussa/go-sentitweet/analysis"
+	"github.com/jmoussa/go-sentitweet/export"
+)
+
+/*
+File sink (`write_files` stage, `tw pipeline --output=csv --chunk-size=100 --output-path=./output/`).
+Scored tweets are written to chunk files of chunk_size tweets (CSV, NDJSON or Parquet) for teams without a database.
+A chunk is written under a hidden .tmp name and renamed once complete, so readers only ever see whole chunks,
+and manifest.json in the output directory lists every completed chunk (replaced atomically after each one).
+*/
+
+const (
+	defaultChunkSize  = 100
+	defaultOutputPath = "output"
+	manifestName      = "manifest.json"
+)
+
+// ChunkInfo describes one completed chunk file in the manifest
+type ChunkInfo struct {
+	File      string    `json:"file"`
+	Format    string    `json:"format"`
+	Tweets    int       `json:"tweets"`
+	Stage     string    `json:"stage"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type Manifest struct {
+	Chunks []ChunkInfo `json:"chunks"`
+}
+
+// every sink of the process updates the manifest of its directory under this lock
+var manifestMu sync.Mutex
+
+type FileSink struct {
+	dir       string
+	format    string
+	chunkSize int
+	// chunk files are named <stage>-<run start>-<sequence>.<format>
+	prefix string
+	stage  string
+
+	mu     sync.Mutex
+	file   *os.File
+	writer export.Writer
+	count  int
+	seq    int
+}
+
+// NewFileSink writes chunks of chunkSize tweets in format to dir, created if needed
+func NewFileSink(dir, format string, chunkSize int, stage string) (*FileSink, error) {
+	switch format {
+	case export.FormatCSV, export.FormatNDJSON, export.FormatParquet:
+	default:
+		return nil, fmt.Errorf("unknown output format %q (expected %s, %s or %s)", format, export.FormatCSV, export.FormatNDJSON, export.FormatParquet)
+	}
+	if chunkSize < 1 {
+		return nil, fmt.Errorf("chunk size must be positive, got %d", chunkSize)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileSink{
+		dir:       dir,
+		format:    format,
+		chunkSize: chunkSize,
+		prefix:    stage + "-" + time.Now().UTC().Format("20060102T150405Z"),
+		stage:     stage,
+	}, nil
+}
+
+// Write is the sink's StepFunc, it appends the tweet to the current chunk and completes the chunk once full
+func (s *FileSink) Write(v interface{}) (interface{}, error) {
+	msg, ok := v.(analysis.TweetWithScoreMessage)
+	if !ok {
+		return nil, fmt.Errorf("file sink: expected a TweetWithScoreMessage, got %T", v)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.writer == nil {
+		if err := s.open(); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.writer.Write(msg); err != nil {
+		return nil, fmt.Errorf("file sink: could not write tweet %d to %s: %w", msg.BaseTweet.ID, s.file.Name(), err)
+	}
+	s.count++
+	if s.count == s.chunkSize {
+		if err := s.complete(); err != nil {
+			return nil, err
+		}
+	}
+	return msg, nil
+}
+
+// Close completes the last, partial chunk
+func (s *FileSink) Close(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.writer == nil {
+		return nil
+	}
+	return s.complete()
+}
+
+func (s *FileSink) chunkName() string {
+	return fmt.Sprintf("%s-%06d.%s", s.prefix, s.seq, s.format)
+}
+
+func (s *FileSink) open() error {
+	s.seq++
+	file, err := os.Create(filepath.Join(s.dir, "."+s.chunkName()+".tmp"))
+	if err != nil {
+		return fmt.Errorf("file sink: %w", err)
+	}
+	writer, err := export.NewWriter(file, s.format)
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	s.file, s.writer, s.count = file, writer, 0
+	return nil
+}
+
+// complete finishes the current chunk, renames it to its final name and adds it to the manifest
+func (s *FileSink) complete() error {
+	file, writer, count := s.file, s.writer, s.count
+	s.file, s.writer, s.count = nil, nil, 0
+	err := writer.Close()
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("file sink: could not complete %s: %w", file.Name(), err)
+	}
+	name := s.chunkName()
+	if err := os.Rename(file.Name(), filepath.Join(s.dir, name)); err != nil {
+		return fmt.Errorf("file sink: %w", err)
+	}
+	return addToManifest(s.dir, ChunkInfo{File: name, Format: s.format, Tweets: count, Stage: s.stage, CreatedAt: time.Now().UTC()})
+}
+
+// ReadManifest reads the manifest of dir, empty when there is none yet
+func ReadManifest(dir string) (Manifest, error) {
+	var manifest Manifest
+	raw, err := os.ReadFile(filepath.Join(dir, manifestName))
+	if errors.Is(err, os.ErrNotExist) {
+		return manifest, nil
+	}
+	if err != nil {
+		return manifest, err
+	}
+	if err := json.Unmarshal(raw, &manifest); err != nil {
+		return manifest, fmt.Errorf("invalid manifest %s: %w", filepath.Join(dir, manifestName), err)
+	}
+	return manifest, nil
+}
+
+func addToManifest(dir string, chunk ChunkInfo) error {
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+	manifest, err := ReadManifest(dir)
+	if err != nil {
+		return err
+	}
+	manifest.Chunks = append(manifest.Chunks, chunk)
+	raw, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, "."+manifestName+".tmp")
+	if err := os.WriteFile(tmp, raw, 0o644); err != nil {
+		return fmt.Errorf("file sink: could not write the manifest: %w", err)
+	}
+	return os.Rename(tmp, filepath.Join(dir, manifestName))
+}
+
+// newFileSinkStep builds the write_files stage from its "format", "chunk_size" and "output_path" params
+func newFileSinkStep(stage map[string]string) (Step, error) {
+	format := stage["format"]
+	if format == "" {
+		format = export.FormatCSV
+	}
+	chunkSize := defaultChunkSize
+	if raw := stage["chunk_size"]; raw != "" {
+		var err error
+		if chunkSize, err = strconv.Atoi(raw); err != nil {
+			return Step{}, fmt.Errorf("invalid chunk_size %q", raw)
+		}
+	}
+	dir := stage["output_path"]
+	if dir == "" {
+		dir = defaultOutputPath
+	}
+	sink, err := NewFileSink(dir, format, chunkSize, stage["name"])
+	if err != nil {
+		return Step{}, err
+	}
+	return Step{Fn: counted(&stats.written, tagTerm(stage["term"], sink.Write)), Close: sink.Close}, nil
+}
+
+// fileOutputStage is the name of the file sink WithFileOutput adds
+const fileOutputStage = "files"
+
+// WithFileOutput writes what the format_and_upload sinks receive to chunk files (params of the write_files stage)
+// as well, or instead of uploading it when replaceStore is set
+func WithFileOutput(format string, chunkSize int, outputPath string, replaceStore bool) Option {
+	return func(p *Pipeline) error {
+		params := map[string]string{
+			"format":      format,
+			"chunk_size":  strconv.Itoa(chunkSize),
+			"output_path": outputPath,
+		}
+		if p.hasStage(fileOutputStage) {
+			return fmt.Errorf("the pipeline already has a %q stage", fileOutputStage)
+		}
+		uploads, dependsOn := p.sinkUpstreams("format_and_upload")
+		if len(uploads) == 0 {
+			return fmt.Errorf("the pipeline has no format_and_upload sink to write files for")
+		}
+		if replaceStore {
+			for _, st := range uploads {
+				st.Function = "write_files"
+				st.Params = withParams(st.Params, params)
+			}
+			return nil
+		}
+		params["name"] = fileOutputStage
+		p.Stages = append(p.Stages, &stage{
+			Name:      fileOutputStage,
+			Type:      stageTypeSink,
+			Function:  "write_files",
+			DependsOn: dependsOn,
+			Params:    params,
+		})
+		return nil
+	}
+}
+
+// withParams is a copy of params with extra set over it
+func withParams(params, extra map[string]string) map[string]string {
+	merged := make(map[string]string, len(params)+len(extra))
+	for k, v := range params {
+		merged[k] = v
+	}
+	for k, v := range extra {
+		merged[k] = v
+	}
+	return merged
+}
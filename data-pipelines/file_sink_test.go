@@ -0,0 +1,129 @@
+package data_pipelines
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jmoussa/go-sentitweet/analysis"
+)
+
+func chunkFiles(t *testing.T, dir string) (complete, partial []string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		switch name := entry.Name(); {
+		case strings.HasSuffix(name, ".tmp"):
+			partial = append(partial, name)
+		case name != manifestName:
+			complete = append(complete, name)
+		}
+	}
+	return complete, partial
+}
+
+func countLines(t *testing.T, path string) int {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	lines := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines++
+	}
+	return lines
+}
+
+func TestFileSinkChunks(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "output")
+	step, err := newFileSinkStep(map[string]string{"name": "files", "format": "ndjson", "chunk_size": "2", "output_path": dir, "term": "#golang"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id := int64(1); id <= 3; id++ {
+		result, err := step.Fn(scored(id, "vader", 0.5))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.(analysis.TweetWithScoreMessage).Term != "#golang" {
+			t.Fatalf("expected the tweet to be tagged with the term, got %+v", result)
+		}
+	}
+	// the first chunk is complete, the third tweet sits in a partial one
+	complete, partial := chunkFiles(t, dir)
+	if len(complete) != 1 || len(partial) != 1 || countLines(t, filepath.Join(dir, complete[0])) != 2 {
+		t.Fatalf("unexpected chunks %v, partial %v", complete, partial)
+	}
+
+	if err := step.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	complete, partial = chunkFiles(t, dir)
+	if len(complete) != 2 || len(partial) != 0 {
+		t.Fatalf("unexpected chunks %v, partial %v", complete, partial)
+	}
+	manifest, err := ReadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Chunks) != 2 || manifest.Chunks[0].Tweets != 2 || manifest.Chunks[1].Tweets != 1 {
+		t.Fatalf("unexpected manifest %+v", manifest)
+	}
+	for _, chunk := range manifest.Chunks {
+		if chunk.Format != "ndjson" || chunk.Stage != "files" || countLines(t, filepath.Join(dir, chunk.File)) != chunk.Tweets {
+			t.Fatalf("manifest entry %+v doesn't match its file", chunk)
+		}
+	}
+
+	if _, err := step.Fn("not a tweet"); err == nil {
+		t.Fatal("expected an error for a message that isn't a scored tweet")
+	}
+	if _, err := newFileSinkStep(map[string]string{"format": "xml", "output_path": dir}); err == nil {
+		t.Fatal("expected an error for an unknown format")
+	}
+}
+
+func TestWithFileOutput(t *testing.T) {
+	p, err := BuildPipeline(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := WithFileOutput("csv", 10, "out", false)(p); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(stageNames(p), ","); got != "lexicon,imdb,merge,upload,files" {
+		t.Fatalf("unexpected stages %s", got)
+	}
+	files := p.Stages[len(p.Stages)-1]
+	if files.Type != stageTypeSink || files.Function != "write_files" || strings.Join(files.DependsOn, ",") != "merge" ||
+		files.Params["chunk_size"] != "10" || files.Params["output_path"] != "out" {
+		t.Fatalf("unexpected file stage %+v", files)
+	}
+	if err := WithFileOutput("csv", 10, "out", false)(p); err == nil {
+		t.Fatal("expected an error when adding the file output twice")
+	}
+
+	p, _ = BuildPipeline(nil)
+	if err := WithFileOutput("parquet", 10, "out", true)(p); err != nil {
+		t.Fatal(err)
+	}
+	upload := p.Stages[len(p.Stages)-1]
+	if len(p.Stages) != 4 || upload.Function != "write_files" || upload.Params["format"] != "parquet" {
+		t.Fatalf("expected the upload to be replaced, got %+v", upload)
+	}
+	if DefaultStages[3]["function"] != "format_and_upload" {
+		t.Fatal("the default stages were modified")
+	}
+
+	p, _ = BuildPipeline([]map[string]string{{"name": "lexicon", "function": "lexicon_sentiment_analysis"}})
+	if err := WithFileOutput("csv", 10, "out", false)(p); err == nil {
+		t.Fatal("expected an error without an upload sink")
+	}
+}
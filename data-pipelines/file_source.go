@@ -0,0 +1,149 @@
+package data_pipelines
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/dghubble/go-twitter/twitter"
+)
+
+/*
+Replay source for archived tweets stored as newline-delimited JSON (optionally gzipped).
+*/
+
+// FileSource replays tweets from a JSONL/NDJSON file.
+// Rate controls pacing: 0 replays as fast as possible, 1 replays at the original
+// tweet timestamps, 2 twice as fast and so on.
+type FileSource struct {
+	Path string
+	Rate float64
+
+	errors   chan error
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func NewFileSource(path string, rate float64) *FileSource {
+	return &FileSource{
+		Path:   path,
+		Rate:   rate,
+		errors: make(chan error, 1),
+		done:   make(chan struct{}),
+	}
+}
+
+func (s *FileSource) Start(ctx context.Context) (<-chan interface{}, error) {
+	if s.Rate < 0 {
+		return nil, fmt.Errorf("invalid replay rate %v: must be >= 0", s.Rate)
+	}
+	f, err := os.Open(s.Path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open replay file: %w", err)
+	}
+	reader, err := decompress(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("could not read replay file %s: %w", s.Path, err)
+	}
+
+	out := make(chan interface{})
+	go func() {
+		defer close(out)
+		defer f.Close()
+		logger().Info().Str("path", s.Path).Msg("Replaying tweets")
+
+		scanner := bufio.NewScanner(reader)
+		// tweets with extended entities easily exceed the default 64KB token size
+		scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+		var (
+			line     int
+			previous time.Time
+		)
+		for scanner.Scan() {
+			line++
+			raw := bytes.TrimSpace(scanner.Bytes())
+			if len(raw) == 0 {
+				continue
+			}
+			var tweet twitter.Tweet
+			if err := json.Unmarshal(raw, &tweet); err != nil {
+				// skip corrupt lines rather than aborting the whole replay
+				logger().Warn().Str("path", s.Path).Int("line", line).Err(err).Msg("Skipping a line that isn't a tweet")
+				continue
+			}
+			// sleep for the gap between this tweet and the previous one, scaled by Rate
+			if s.Rate > 0 {
+				if createdAt, err := tweet.CreatedAtTime(); err == nil {
+					if !previous.IsZero() && createdAt.After(previous) {
+						if !s.wait(ctx, time.Duration(float64(createdAt.Sub(previous))/s.Rate)) {
+							return
+						}
+					}
+					previous = createdAt
+				}
+			}
+			select {
+			case out <- &tweet:
+			case <-s.done:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			s.reportError(fmt.Errorf("%s: %w", s.Path, err))
+			return
+		}
+		logger().Info().Str("path", s.Path).Int("lines", line).Msg("Reached the end of the replay file")
+	}()
+	return out, nil
+}
+
+func (s *FileSource) Errors() <-chan error {
+	return s.errors
+}
+
+func (s *FileSource) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.done)
+	})
+}
+
+func (s *FileSource) wait(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-s.done:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (s *FileSource) reportError(err error) {
+	select {
+	case s.errors <- err:
+	default:
+		logger().Error().Err(err).Msg("Source error")
+	}
+}
+
+func decompress(r io.Reader) (io.Reader, error) {
+	// sniff the gzip magic number rather than trusting the file extension
+	buffered := bufio.NewReader(r)
+	magic, err := buffered.Peek(2)
+	if err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		return gzip.NewReader(buffered)
+	}
+	return buffered, nil
+}
@@ -0,0 +1,68 @@
+package data_pipelines
+
+import (
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dghubble/go-twitter/twitter"
+)
+
+const replayFixture = `{"id": 1, "text": "first", "created_at": "Mon Jan 02 15:04:05 +0000 2006"}
+not json
+
+{"id": 2, "text": "second", "created_at": "Mon Jan 02 15:04:06 +0000 2006"}
+`
+
+func collectTweets(t *testing.T, src Source) []*twitter.Tweet {
+	out, err := src.Start(context.Background())
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	var tweets []*twitter.Tweet
+	for v := range out {
+		tweets = append(tweets, v.(*twitter.Tweet))
+	}
+	return tweets
+}
+
+func TestFileSourceReplaysUntilEOF(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tweets.jsonl")
+	if err := os.WriteFile(path, []byte(replayFixture), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tweets := collectTweets(t, NewFileSource(path, 0))
+	if len(tweets) != 2 {
+		t.Fatalf("expected 2 tweets, got %d", len(tweets))
+	}
+	if tweets[0].ID != 1 || tweets[1].Text != "second" {
+		t.Errorf("unexpected tweets: %+v %+v", tweets[0], tweets[1])
+	}
+}
+
+func TestFileSourceReadsGzip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tweets.jsonl.gz")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(f)
+	gz.Write([]byte(replayFixture))
+	gz.Close()
+	f.Close()
+
+	// the fixture tweets are one second apart, replay them 1000x faster than real time
+	tweets := collectTweets(t, NewFileSource(path, 1000))
+	if len(tweets) != 2 {
+		t.Fatalf("expected 2 tweets, got %d", len(tweets))
+	}
+}
+
+func TestFileSourceMissingFile(t *testing.T) {
+	if _, err := NewFileSource(filepath.Join(t.TempDir(), "missing.jsonl"), 0).Start(context.Background()); err == nil {
+		t.Fatal("expected an error for a missing file")
+	}
+}
@@ -0,0 +1,146 @@
+package data_pipelines
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoussa/go-sentitweet/analysis"
+	"github.com/jmoussa/go-sentitweet/monitoring"
+)
+
+/*
+Fan-in of parallel analysis stages.
+A join stage collects the partial TweetWithScoreMessage emitted by each of its upstream stages,
+keyed by tweet ID, and emits a single message holding all the scores once every upstream reported
+(or once the timeout expires, in which case whatever arrived is emitted).
+Scores arriving after their tweet timed out are dropped, the tweet is never emitted twice.
+*/
+
+const defaultJoinTimeout = 10 * time.Second
+
+// timedOutIDs is the number of timed out tweets a join remembers to drop their late scores
+const timedOutIDs = 4096
+
+// recentIDs is a bounded set of tweet IDs, the oldest is forgotten once it is full
+type recentIDs struct {
+	ids  map[int64]struct{}
+	ring []int64
+	next int
+}
+
+func newRecentIDs(size int) *recentIDs {
+	return &recentIDs{ids: make(map[int64]struct{}, size), ring: make([]int64, 0, size)}
+}
+
+func (r *recentIDs) add(id int64) {
+	if _, exists := r.ids[id]; exists {
+		return
+	}
+	if len(r.ring) < cap(r.ring) {
+		r.ring = append(r.ring, id)
+	} else {
+		delete(r.ids, r.ring[r.next])
+		r.ring[r.next] = id
+		r.next = (r.next + 1) % len(r.ring)
+	}
+	r.ids[id] = struct{}{}
+}
+
+func (r *recentIDs) contains(id int64) bool {
+	_, exists := r.ids[id]
+	return exists
+}
+
+type pendingJoin struct {
+	message  analysis.TweetWithScoreMessage
+	received int
+	deadline time.Time
+}
+
+func join(
+	ctx context.Context,
+	inputChannel <-chan interface{},
+	outputChannel chan interface{},
+	errorChannel chan error,
+	expected int,
+	timeout time.Duration,
+	loggingTrace string,
+) {
+	defer close(outputChannel)
+
+	pending := map[int64]*pendingJoin{}
+	timedOut := newRecentIDs(timedOutIDs)
+	emit := func(p *pendingJoin) bool {
+		select {
+		case outputChannel <- p.message:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	// sweep for expired joins a few times per timeout
+	interval := timeout / 4
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger().Warn().Str(monitoring.FieldStage, loggingTrace).Int("pending", len(pending)).Msg("Join aborted with incomplete tweets")
+			return
+		case now := <-ticker.C:
+			for id, p := range pending {
+				if now.After(p.deadline) {
+					logger().Warn().Str(monitoring.FieldStage, loggingTrace).Int64(monitoring.FieldTweetID, id).Int("received", p.received).Int("expected", expected).Msg("Timed out joining the scores, emitting a partial tweet")
+					delete(pending, id)
+					timedOut.add(id)
+					if !emit(p) {
+						return
+					}
+				}
+			}
+		case v, ok := <-inputChannel:
+			if !ok {
+				// upstreams are done, nothing else is coming for the remaining tweets
+				for _, p := range pending {
+					if !emit(p) {
+						return
+					}
+				}
+				return
+			}
+			msg, isScored := v.(analysis.TweetWithScoreMessage)
+			if !isScored || msg.BaseTweet == nil {
+				select {
+				case errorChannel <- fmt.Errorf("%s: can't join message of type %T", loggingTrace, v):
+				case <-ctx.Done():
+					return
+				}
+				continue
+			}
+			id := msg.BaseTweet.ID
+			if timedOut.contains(id) {
+				logger().Warn().Str(monitoring.FieldStage, loggingTrace).Int64(monitoring.FieldTweetID, id).Msg("Dropping a score that arrived after its tweet timed out")
+				continue
+			}
+			p, exists := pending[id]
+			if !exists {
+				p = &pendingJoin{deadline: time.Now().Add(timeout)}
+				pending[id] = p
+			}
+			p.message.Merge(msg)
+			p.received++
+			if p.received >= expected {
+				delete(pending, id)
+				if !emit(p) {
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package data_pipelines
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dghubble/go-twitter/twitter"
+	"github.com/jmoussa/go-sentitweet/analysis"
+)
+
+func scored(id int64, scorer string, compound float64) analysis.TweetWithScoreMessage {
+	return analysis.TweetWithScoreMessage{
+		BaseTweet: &twitter.Tweet{ID: id},
+		Scores:    map[string]analysis.SentimentResult{scorer: {Scorer: scorer, Compound: compound}},
+	}
+}
+
+func TestJoinMergesScoresByTweetID(t *testing.T) {
+	in := make(chan interface{})
+	out := make(chan interface{}, 10)
+	go join(context.Background(), in, out, make(chan error, 1), 2, time.Minute, "test")
+
+	in <- scored(1, "vader", 0.5)
+	in <- scored(2, "vader", 0.1)
+	in <- scored(1, "imdb", 1)
+
+	msg := (<-out).(analysis.TweetWithScoreMessage)
+	if msg.BaseTweet.ID != 1 || len(msg.Scores) != 2 {
+		t.Fatalf("expected both scores for tweet 1, got %+v", msg)
+	}
+
+	// closing the input flushes incomplete joins
+	close(in)
+	msg = (<-out).(analysis.TweetWithScoreMessage)
+	if msg.BaseTweet.ID != 2 || len(msg.Scores) != 1 {
+		t.Fatalf("expected partial scores for tweet 2, got %+v", msg)
+	}
+	if _, open := <-out; open {
+		t.Fatal("expected output to be closed")
+	}
+}
+
+func TestJoinEmitsPartialOnTimeout(t *testing.T) {
+	in := make(chan interface{})
+	out := make(chan interface{}, 1)
+	go join(context.Background(), in, out, make(chan error, 1), 2, 20*time.Millisecond, "test")
+	defer close(in)
+
+	in <- scored(1, "vader", 0.5)
+	select {
+	case v := <-out:
+		if msg := v.(analysis.TweetWithScoreMessage); len(msg.Scores) != 1 {
+			t.Fatalf("expected the vader score only, got %+v", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("join didn't time out")
+	}
+}
+
+func TestJoinDropsLateScores(t *testing.T) {
+	in := make(chan interface{})
+	out := make(chan interface{}, 2)
+	go join(context.Background(), in, out, make(chan error, 1), 2, 20*time.Millisecond, "test")
+
+	in <- scored(1, "vader", 0.5)
+	select {
+	case <-out:
+	case <-time.After(time.Second):
+		t.Fatal("join didn't time out")
+	}
+	in <- scored(1, "imdb", 1)
+	close(in)
+	if v, open := <-out; open {
+		t.Fatalf("expected the late score to be dropped, got %+v", v)
+	}
+}
+
+func TestRecentIDs(t *testing.T) {
+	r := newRecentIDs(2)
+	r.add(1)
+	r.add(2)
+	r.add(2)
+	r.add(3)
+	if r.contains(1) || !r.contains(2) || !r.contains(3) {
+		t.Fatalf("expected the oldest ID to be forgotten, got %v", r.ids)
+	}
+}
+
+func TestJoinRejectsUnscoredMessages(t *testing.T) {
+	in := make(chan interface{})
+	errs := make(chan error, 1)
+	go join(context.Background(), in, make(chan interface{}), errs, 2, time.Minute, "test")
+	defer close(in)
+
+	in <- &twitter.Tweet{ID: 1}
+	if err := <-errs; err == nil {
+		t.Fatal("expected an error")
+	}
+}
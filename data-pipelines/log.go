@@ -0,0 +1,38 @@
+package data_pipelines
+
+import (
+	"github.com/dghubble/go-twitter/twitter"
+	"github.com/jmoussa/go-sentitweet/analysis"
+	"github.com/jmoussa/go-sentitweet/config"
+	"github.com/jmoussa/go-sentitweet/monitoring"
+	"github.com/rs/zerolog"
+)
+
+// configureLogging applies the logging settings of the config, before anything is logged
+func configureLogging(cfg config.Config) {
+	if err := monitoring.ConfigureLogging(cfg); err != nil {
+		logger().Fatal().Err(err).Msg("Invalid logging config")
+	}
+}
+
+func logger() *zerolog.Logger {
+	return monitoring.For("data_pipelines")
+}
+
+// withTweet adds the tweet ID (and term once tagged) of a pipeline message to a log event
+func withTweet(e *zerolog.Event, v interface{}) *zerolog.Event {
+	switch msg := v.(type) {
+	case *twitter.Tweet:
+		if msg != nil {
+			e = e.Int64(monitoring.FieldTweetID, msg.ID)
+		}
+	case analysis.TweetWithScoreMessage:
+		if msg.BaseTweet != nil {
+			e = e.Int64(monitoring.FieldTweetID, msg.BaseTweet.ID)
+		}
+		if msg.Term != "" {
+			e = e.Str(monitoring.FieldTerm, msg.Term)
+		}
+	}
+	return e
+}
@@ -0,0 +1,52 @@
+package data_pipelines
+
+import (
+	"fmt"
+
+	"github.com/jmoussa/go-sentitweet/analysis"
+	"github.com/jmoussa/go-sentitweet/config"
+)
+
+// publishStage is the name of the sink WithPublisher adds
+const publishStage = "publish"
+
+// WithPublisher hands every tweet the pipeline's sinks receive to publish as well (the API's live stream hub),
+// publish must not block
+func WithPublisher(publish func(analysis.TweetWithScoreMessage)) Option {
+	return func(p *Pipeline) error {
+		if p.hasStage(publishStage) {
+			return fmt.Errorf("the pipeline already has a %q stage", publishStage)
+		}
+		sinks, dependsOn := p.sinkUpstreams("")
+		if len(sinks) == 0 {
+			return fmt.Errorf("the pipeline has no sink to publish the tweets of")
+		}
+		p.Stages = append(p.Stages, &stage{
+			Name:      publishStage,
+			Type:      stageTypeSink,
+			DependsOn: dependsOn,
+			Params:    map[string]string{"name": publishStage},
+			factory: func(stage map[string]string, cfg config.Config) (Step, error) {
+				return Step{Fn: tagTerm(stage["term"], publishStep(publish))}, nil
+			},
+		})
+		return nil
+	}
+}
+
+func publishStep(publish func(analysis.TweetWithScoreMessage)) StepFunc {
+	return func(s interface{}) (interface{}, error) {
+		msg, ok := s.(analysis.TweetWithScoreMessage)
+		if !ok || msg.BaseTweet == nil {
+			return nil, fmt.Errorf("publish: expected a scored tweet, got %T", s)
+		}
+		// the stores set the creation date when writing, live subscribers get it too
+		if msg.CreatedAt.IsZero() {
+			if createdAt, err := msg.BaseTweet.CreatedAtTime(); err == nil {
+				msg.CreatedAt = createdAt.UTC()
+			}
+		}
+		publish(msg)
+		return msg, nil
+	}
+}
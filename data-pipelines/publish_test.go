@@ -0,0 +1,54 @@
+package data_pipelines
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dghubble/go-twitter/twitter"
+	"github.com/jmoussa/go-sentitweet/analysis"
+)
+
+func TestWithPublisher(t *testing.T) {
+	var published []analysis.TweetWithScoreMessage
+	p, err := BuildPipeline(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := WithFileOutput("csv", 10, t.TempDir(), false)(p); err != nil {
+		t.Fatal(err)
+	}
+	if err := WithPublisher(func(msg analysis.TweetWithScoreMessage) { published = append(published, msg) })(p); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(stageNames(p), ","); got != "lexicon,imdb,merge,upload,files,publish" {
+		t.Fatalf("unexpected stages %s", got)
+	}
+	// published once per tweet, next to the upload and file sinks rather than after them
+	publish := p.Stages[len(p.Stages)-1]
+	if strings.Join(publish.DependsOn, ",") != "merge" {
+		t.Fatalf("unexpected publish dependencies %v", publish.DependsOn)
+	}
+
+	p.Term = "#golang"
+	step, err := publish.factory(p.stageParams(publish), p.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	createdAt := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	msg := scored(1, "vader", 0.5)
+	msg.BaseTweet.CreatedAt = createdAt.Format(time.RubyDate)
+	if _, err := step.Fn(msg); err != nil {
+		t.Fatal(err)
+	}
+	if len(published) != 1 || published[0].Term != "#golang" || !published[0].CreatedAt.Equal(createdAt) {
+		t.Fatalf("unexpected published tweets %+v", published)
+	}
+	if _, err := step.Fn(&twitter.Tweet{ID: 2}); err == nil {
+		t.Fatal("expected an error for an unscored tweet")
+	}
+
+	if err := WithPublisher(func(analysis.TweetWithScoreMessage) {})(p); err == nil {
+		t.Fatal("expected an error when publishing twice")
+	}
+}
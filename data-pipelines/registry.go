@@ -0,0 +1,153 @@
+package data_pipelines
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoussa/go-sentitweet/analysis"
+	"github.com/jmoussa/go-sentitweet/config"
+	"github.com/jmoussa/go-sentitweet/db"
+)
+
+/*
+Registries of the named building blocks that the `stages` section of config.json refers to.
+Steps are looked up by their "function" and sources by the "function" of the source stage.
+*/
+
+// StepFunc processes one message flowing through the pipeline
+type StepFunc func(interface{}) (interface{}, error)
+
+// Step is an instantiated stage, Close (optional) releases its resources once the pipeline has drained
+type Step struct {
+	Fn    StepFunc
+	Close func(ctx context.Context) error
+	// Errors (optional) reports the failures of work the step finishes in the background,
+	// it must be closed once Close returns
+	Errors <-chan error
+	// Concurrency is the step's preferred concurrency when the stage doesn't set one
+	Concurrency int
+}
+
+// StepFactory builds a Step from the stage's config entry, so steps can take parameters and hold resources
+type StepFactory func(stage map[string]string, cfg config.Config) (Step, error)
+
+// SourceFactory builds a Source from the source stage's config entry
+type SourceFactory func(stage map[string]string, cfg config.Config) (Source, error)
+
+var (
+	stepRegistry   = map[string]StepFactory{}
+	sourceRegistry = map[string]SourceFactory{}
+)
+
+func RegisterStep(name string, factory StepFactory) {
+	if _, exists := stepRegistry[name]; exists {
+		panic(fmt.Sprintf("step %q registered twice", name))
+	}
+	stepRegistry[name] = factory
+}
+
+// RegisterStepFunc registers a step that takes no parameters
+func RegisterStepFunc(name string, fn StepFunc) {
+	RegisterStep(name, func(map[string]string, config.Config) (Step, error) {
+		return Step{Fn: fn}, nil
+	})
+}
+
+func RegisterSource(name string, factory SourceFactory) {
+	if _, exists := sourceRegistry[name]; exists {
+		panic(fmt.Sprintf("source %q registered twice", name))
+	}
+	sourceRegistry[name] = factory
+}
+
+// StepNames lists the registered step functions, sorted
+func StepNames() []string {
+	names := make([]string, 0, len(stepRegistry))
+	for name := range stepRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	RegisterStepFunc("lexicon_sentiment_analysis", counted(&stats.scored, analysis.LexiconSentimentAnalysis))
+	RegisterStepFunc("imdb_sentiment_analysis", counted(&stats.scored, analysis.IMDBModelSentimentAnalysis))
+	// generic scoring step, the stage picks the analysis scorer with "scorer"
+	RegisterStep("score", func(stage map[string]string, cfg config.Config) (Step, error) {
+		if stage["scorer"] == "" {
+			return Step{}, fmt.Errorf("score stage %q needs a \"scorer\" (available: %s)", stage["name"], strings.Join(analysis.ScorerNames(), ", "))
+		}
+		fn, err := analysis.ScoringStep(stage["scorer"])
+		if err != nil {
+			return Step{}, err
+		}
+		return Step{Fn: counted(&stats.scored, fn)}, nil
+	})
+	// one store (pooled mongo client) per upload stage, flushed and closed after the drain
+	RegisterStep("format_and_upload", func(stage map[string]string, cfg config.Config) (Step, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		store, err := db.OpenStore(ctx, cfg)
+		if err != nil {
+			return Step{}, err
+		}
+		uploader, err := db.NewTweetUploader(store, cfg, func(msg analysis.TweetWithScoreMessage) {
+			stats.count(&stats.stored, msg)
+		})
+		if err != nil {
+			store.Close(ctx)
+			return Step{}, err
+		}
+		return Step{
+			// tag tweets with the term they were collected for, the store indexes term+date.
+			// Upload only queues them, they are counted once written
+			Fn:     tagTerm(stage["term"], uploader.Upload),
+			Close:  uploader.Close,
+			Errors: uploader.Errors(),
+		}, nil
+	})
+	// chunked CSV/NDJSON/Parquet files, alongside or instead of the database
+	RegisterStep("write_files", func(stage map[string]string, cfg config.Config) (Step, error) {
+		return newFileSinkStep(stage)
+	})
+
+	RegisterSource("twitter", func(stage map[string]string, cfg config.Config) (Source, error) {
+		term := stage["term"]
+		if term == "" {
+			term = "#nft"
+		}
+		return NewTwitterStreamSource(term, cfg), nil
+	})
+	RegisterSource("file", func(stage map[string]string, cfg config.Config) (Source, error) {
+		if stage["input"] == "" {
+			return nil, fmt.Errorf("file source %q needs an \"input\" path", stage["name"])
+		}
+		var rate float64
+		if stage["rate"] != "" {
+			var err error
+			if rate, err = strconv.ParseFloat(stage["rate"], 64); err != nil {
+				return nil, fmt.Errorf("file source %q has an invalid rate %q", stage["name"], stage["rate"])
+			}
+		}
+		return NewFileSource(stage["input"], rate), nil
+	})
+}
+
+// tagTerm sets the Term of the tweets fn gets that don't have one yet, fn itself when term is empty
+func tagTerm(term string, fn StepFunc) StepFunc {
+	if term == "" {
+		return fn
+	}
+	return func(s interface{}) (interface{}, error) {
+		if msg, ok := s.(analysis.TweetWithScoreMessage); ok && msg.Term == "" {
+			msg.Term = term
+			s = msg
+		}
+		return fn(s)
+	}
+}
@@ -0,0 +1,133 @@
+package data_pipelines
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/dghubble/go-twitter/twitter"
+	"github.com/dghubble/oauth1"
+	"github.com/jmoussa/go-sentitweet/config"
+	"github.com/jmoussa/go-sentitweet/monitoring"
+)
+
+/*
+Tweet sources feeding the head of the pipeline.
+A Source emits *twitter.Tweet values (as interface{} so they flow straight into step) until it is exhausted or stopped.
+*/
+
+type Source interface {
+	// Start begins emitting tweets on the returned channel, which is closed once the source is exhausted or stopped
+	Start(ctx context.Context) (<-chan interface{}, error)
+	// Errors reports problems that happen after Start (disconnects, read errors, ...)
+	Errors() <-chan error
+	// Stop asks the source to stop emitting and close its output channel
+	Stop()
+}
+
+// termSource is implemented by sources that collect tweets for a search term
+type termSource interface {
+	Term() string
+}
+
+// TwitterStreamSource streams tweets from the Twitter v1.1 filter stream
+type TwitterStreamSource struct {
+	SearchPhrase string
+	Config       config.Config
+
+	errors   chan error
+	stream   *twitter.Stream
+	stopOnce sync.Once
+}
+
+func NewTwitterStreamSource(searchPhrase string, cfg config.Config) *TwitterStreamSource {
+	return &TwitterStreamSource{
+		SearchPhrase: searchPhrase,
+		Config:       cfg,
+		errors:       make(chan error, 1),
+	}
+}
+
+func (s *TwitterStreamSource) Term() string {
+	return s.SearchPhrase
+}
+
+func (s *TwitterStreamSource) Start(ctx context.Context) (<-chan interface{}, error) {
+	con := s.Config.General
+	c := oauth1.NewConfig(con["consumerkey"], con["consumersecret"])
+	token := oauth1.NewToken(con["accesstoken"], con["accesssecret"])
+	httpClient := c.Client(oauth1.NoContext, token)
+
+	// intialize stream
+	client := twitter.NewClient(httpClient)
+	params := &twitter.StreamFilterParams{
+		Track:         []string{s.SearchPhrase},
+		StallWarnings: twitter.Bool(true),
+	}
+	stream, err := client.Streams.Filter(params)
+	if err != nil {
+		return nil, fmt.Errorf("error querying stream: %w", err)
+	}
+	s.stream = stream
+
+	out := make(chan interface{})
+	go func() {
+		defer close(out)
+
+		// Initialize demux for interface{} type processing to channel
+		demux := twitter.NewSwitchDemux()
+		logger().Info().Str(monitoring.FieldTerm, s.SearchPhrase).Msg("Searching")
+		demux.Tweet = func(tweet *twitter.Tweet) {
+			select {
+			case out <- tweet:
+			case <-ctx.Done():
+			}
+		}
+		demux.Warning = func(warning *twitter.StallWarning) {
+			logger().Warn().Str(monitoring.FieldTerm, s.SearchPhrase).Int("percent_full", warning.PercentFull).Msgf("Stall warning from stream: %s", warning.Message)
+		}
+		demux.StreamDisconnect = func(disconnect *twitter.StreamDisconnect) {
+			s.reportError(fmt.Errorf("stream disconnected: %s (code %d)", disconnect.Reason, disconnect.Code))
+		}
+		demux.Other = func(message interface{}) {
+			// the stream pushes HTTP/decoding errors through Messages as well
+			if err, ok := message.(error); ok {
+				s.reportError(err)
+			}
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				s.Stop()
+				return
+			case message, ok := <-stream.Messages:
+				if !ok {
+					return
+				}
+				demux.Handle(message)
+			}
+		}
+	}()
+	return out, nil
+}
+
+func (s *TwitterStreamSource) Errors() <-chan error {
+	return s.errors
+}
+
+func (s *TwitterStreamSource) Stop() {
+	s.stopOnce.Do(func() {
+		if s.stream != nil {
+			s.stream.Stop()
+		}
+	})
+}
+
+func (s *TwitterStreamSource) reportError(err error) {
+	// never block the stream on an unread error
+	select {
+	case s.errors <- err:
+	default:
+		logger().Error().Err(err).Msg("Source error")
+	}
+}
@@ -0,0 +1,112 @@
+package data_pipelines
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/jmoussa/go-sentitweet/analysis"
+	"github.com/jmoussa/go-sentitweet/monitoring"
+	"github.com/rs/zerolog"
+)
+
+/*
+Counters for the current pipeline run, logged as a summary when the pipeline stops.
+Every count also goes to the per term Prometheus counters, labelled with the tweet's term or else the run's.
+*/
+
+type pipelineStats struct {
+	received int64
+	// one per scorer per tweet, so a fan-out to two scorers counts each tweet twice
+	scored int64
+	stored int64
+	// written to chunk files by the file sinks
+	written int64
+	failed  int64
+	// term of the run (a string), for the tweets that aren't tagged with theirs
+	term atomic.Value
+}
+
+var stats pipelineStats
+
+func (s *pipelineStats) reset() {
+	atomic.StoreInt64(&s.received, 0)
+	atomic.StoreInt64(&s.scored, 0)
+	atomic.StoreInt64(&s.stored, 0)
+	atomic.StoreInt64(&s.written, 0)
+	atomic.StoreInt64(&s.failed, 0)
+	s.term.Store("")
+}
+
+func (s *pipelineStats) setTerm(term string) {
+	s.term.Store(term)
+}
+
+// outcome names a counter in the metrics
+func (s *pipelineStats) outcome(counter *int64) string {
+	switch counter {
+	case &s.received:
+		return "received"
+	case &s.scored:
+		return "scored"
+	case &s.stored:
+		return "stored"
+	case &s.written:
+		return "written"
+	default:
+		return "failed"
+	}
+}
+
+// count increments one of the counters for a tweet, v is the message when there is one
+func (s *pipelineStats) count(counter *int64, v interface{}) {
+	atomic.AddInt64(counter, 1)
+	term, _ := s.term.Load().(string)
+	msg, scored := v.(analysis.TweetWithScoreMessage)
+	if scored && msg.Term != "" {
+		term = msg.Term
+	}
+	outcome := s.outcome(counter)
+	monitoring.PipelineTweets.WithLabelValues(term, outcome).Inc()
+	if outcome == "scored" {
+		for scorer, score := range msg.Scores {
+			monitoring.ObserveSentiment(term, scorer, score.Compound)
+		}
+	}
+}
+
+// MarshalZerologObject adds the counters to a log entry as fields
+func (s *pipelineStats) MarshalZerologObject(e *zerolog.Event) {
+	e.Int64("received", atomic.LoadInt64(&s.received)).
+		Int64("scored", atomic.LoadInt64(&s.scored)).
+		Int64("stored", atomic.LoadInt64(&s.stored)).
+		Int64("written", atomic.LoadInt64(&s.written)).
+		Int64("failed", atomic.LoadInt64(&s.failed))
+}
+
+// counted wraps a step so its successful results increment counter
+func counted(counter *int64, fn StepFunc) StepFunc {
+	return func(s interface{}) (interface{}, error) {
+		result, err := fn(s)
+		if err == nil {
+			stats.count(counter, result)
+		}
+		return result, err
+	}
+}
+
+// countReceived forwards the source's tweets, counting them on the way
+func countReceived(ctx context.Context, in <-chan interface{}) <-chan interface{} {
+	out := make(chan interface{})
+	go func() {
+		defer close(out)
+		for v := range in {
+			stats.count(&stats.received, v)
+			select {
+			case out <- v:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
@@ -3,17 +3,18 @@ package data_pipelines
 import (
 	"context"
 	"encoding/json"
-	"log"
 	"net/http"
-	"runtime"
+	"os"
+	"os/signal"
+	"sync"
 	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/arl/statsviz"
-	"github.com/dghubble/go-twitter/twitter"
-	"github.com/dghubble/oauth1"
-	"github.com/jmoussa/go-sentitweet/analysis"
 	"github.com/jmoussa/go-sentitweet/config"
 	"github.com/jmoussa/go-sentitweet/monitoring"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"golang.org/x/sync/semaphore"
 )
 
@@ -22,45 +23,6 @@ Sentiment Analysis Pipeline
 Starts multiple goroutines to run the sentiment analysis pipeline concurrently on the number of cores available
 */
 
-// Parse JSON config for use
-var cfg config.Config = config.ParseConfig()
-
-func generator(searchPhrase string, cfg config.Config) chan interface{} {
-	// Starts up a generator stream of tweets into the outputted channel
-	out := make(chan interface{})
-	go func() {
-		defer close(out)
-
-		con := cfg.General
-		c := oauth1.NewConfig(con["consumerkey"], con["consumersecret"])
-		token := oauth1.NewToken(con["accesstoken"], con["accesssecret"])
-		httpClient := c.Client(oauth1.NoContext, token)
-
-		// intialize stream
-		client := twitter.NewClient(httpClient)
-		params := &twitter.StreamFilterParams{
-			Track:         []string{searchPhrase},
-			StallWarnings: twitter.Bool(true),
-		}
-		stream, err := client.Streams.Filter(params)
-		if err != nil {
-			log.Fatalf("Error querying stream, %s\n", err)
-		}
-		defer stream.Stop()
-
-		// Initialize demux for interface{} type processing to channel
-		demux := twitter.NewSwitchDemux()
-		log.Println("Searching for:", searchPhrase)
-		demux.Tweet = func(tweet *twitter.Tweet) {
-			out <- tweet
-		}
-		for message := range stream.Messages {
-			demux.Handle(message)
-		}
-	}()
-	return out
-}
-
 func mergeAtomic(outputChan chan interface{}, cs ...<-chan interface{}) <-chan interface{} {
 	// Atomically dump each channel into the output channel and return output channel
 	var i int32
@@ -78,143 +40,323 @@ func mergeAtomic(outputChan chan interface{}, cs ...<-chan interface{}) <-chan i
 	return outputChan
 }
 
-func sink(ctx context.Context, cancelFunc context.CancelFunc, values <-chan interface{}, errors <-chan error) {
+func sink(ctx context.Context, values <-chan interface{}, errors <-chan error) {
 	var count int64 = 0
 	for {
 		select {
 		case <-ctx.Done():
-			log.Print(ctx.Err().Error())
+			logger().Warn().Err(ctx.Err()).Msg("Pipeline aborted")
 			return
 		case err := <-errors:
-			if err != nil {
-				log.Println("error: ", err.Error())
-				cancelFunc()
-			}
+			tweetFailed(err)
 		case _, ok := <-values:
 			if ok {
 				count += 1
 				if count%100 == 0 {
-					log.Printf("Tweet count: %d", count)
+					logger().Info().Int64("count", count).Msg("Tweet count")
 				}
 			} else {
-				log.Print("done")
+				logger().Info().Int64("count", count).Msg("Done, final tweet count")
 				return
 			}
 		}
 	}
 }
 
+// tweetFailed counts and logs a failing tweet, it doesn't stop the rest of the pipeline
+func tweetFailed(err error) {
+	if err != nil {
+		stats.count(&stats.failed, nil)
+		logger().Error().Err(err).Msg("Tweet failed")
+	}
+}
+
+// forwardErrors hands the background failures of a step to the sink until the step closes them
+func forwardErrors(ctx context.Context, errs <-chan error, errorChannel chan<- error, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for err := range errs {
+		select {
+		case errorChannel <- err:
+		case <-ctx.Done():
+			logger().Error().Err(err).Msg("Tweet failed after the pipeline aborted")
+		}
+	}
+}
+
+// closeStepsCounting releases db clients and other step resources while still counting the failures
+// the steps report as they close (the uploader's last batch)
+func closeStepsCounting(steps []Step, errorChannel <-chan error, forwarding *sync.WaitGroup) {
+	closed := make(chan struct{})
+	go func() {
+		closeSteps(steps)
+		forwarding.Wait()
+		close(closed)
+	}()
+	for {
+		select {
+		case err := <-errorChannel:
+			tweetFailed(err)
+		case <-closed:
+			return
+		}
+	}
+}
+
 func step[In any, Out any](
 	ctx context.Context,
 	inputChannel <-chan In,
 	outputChannel chan Out,
 	errorChannel chan error,
 	fn func(In) (Out, error),
+	limit int,
 	loggingTrace string,
+	traces *monitoring.Logger,
 ) {
 	defer close(outputChannel)
 
-	// create a new semaphore with a limit (of the CPU count) for processes the semaphore can access at a time
-	limit := runtime.NumCPU()
+	// create a new semaphore with a limit (the stage's concurrency) for processes the semaphore can access at a time
 	sem1 := semaphore.NewWeighted(int64(limit))
+	// wait for in-flight messages before closing the output so nothing is lost while draining
+	var inFlight sync.WaitGroup
+	defer inFlight.Wait()
+
+	// in flight / concurrency is the semaphore saturation
+	monitoring.StageConcurrency.WithLabelValues(loggingTrace).Set(float64(limit))
+	inFlightGauge := monitoring.StageInFlight.WithLabelValues(loggingTrace)
+	queueDepth := monitoring.StageQueueDepth.WithLabelValues(loggingTrace)
+	semaphoreWait := monitoring.StageSemaphoreWait.WithLabelValues(loggingTrace)
+	duration := monitoring.StageDuration.WithLabelValues(loggingTrace)
 
 	// parse through messages in input channel
 	for s := range inputChannel {
-		select {
-		// if cancelled, abort operation otherwise run while there's values in inputChannel
-		case <-ctx.Done():
-			log.Println("1 abort")
-			break
-		default:
-		}
-		// use semaphores to keep data integrity
+		// the message and whatever is buffered behind it wait for a free slot
+		queueDepth.Set(float64(len(inputChannel) + 1))
+		waitStarted := time.Now()
+		// use semaphores to keep data integrity, acquiring only fails once the context is cancelled
 		if err := sem1.Acquire(ctx, 1); err != nil {
-			log.Printf("Failed to acquire semaphore: %v", err)
-			break
+			queueDepth.Set(0)
+			logger().Warn().Str(monitoring.FieldStage, loggingTrace).Err(err).Msg("Stage aborting")
+			return
 		}
+		semaphoreWait.Observe(time.Since(waitStarted).Seconds())
+		queueDepth.Set(float64(len(inputChannel)))
 
-		// start up go functions to parallelize processing to CPU Count
+		// start up go functions to parallelize processing up to the stage's concurrency
+		inFlight.Add(1)
+		inFlightGauge.Inc()
 		go func(s In) {
 			// release the semaphore at the end of this concurrent process
+			defer inFlight.Done()
 			defer sem1.Release(1)
-			msg, err := json.Marshal(s)
-			if err != nil {
-				log.Println("Error marshalling: ", err)
-			}
-			msgStr := string(msg)
-			// send and schedule start and stop log messages to SNS
-			log := monitoring.Log{
-				Message:   msgStr,
-				Level:     "INFO",
-				Type:      "Start",
-				Timestamp: monitoring.GetTimestamp(),
+			defer inFlightGauge.Dec()
+			// trace the start and stop of every message at the debug level, only marshalled when delivered
+			if traces.Enabled(monitoring.LevelDebug) {
+				msg, err := json.Marshal(s)
+				if err != nil {
+					logger().Warn().Str(monitoring.FieldStage, loggingTrace).Err(err).Msg("Could not marshal the trace entry")
+				}
+				entry := monitoring.Log{
+					Message: string(msg),
+					Level:   monitoring.LevelDebug,
+					Type:    "Start",
+					Stage:   loggingTrace,
+				}
+				traces.Log(entry)
+				defer func() {
+					entry.Type = "Stop"
+					traces.Log(entry)
+				}()
 			}
-			monitoring.SendLogMessageToSNS(&log)
-			log.Type = "Stop"
-			defer monitoring.SendLogMessageToSNS(&log)
 
 			// Take the result of the function and send to outputChannel
+			started := time.Now()
 			result, err := fn(s)
+			duration.Observe(time.Since(started).Seconds())
+			if e := logger().Debug(); e.Enabled() {
+				withTweet(e, s).Str(monitoring.FieldStage, loggingTrace).Dur(monitoring.FieldLatency, time.Since(started)).Err(err).Msg("Processed")
+			}
 			if err != nil {
-				errorChannel <- err
+				select {
+				case errorChannel <- err:
+				case <-ctx.Done():
+				}
 			} else {
-				outputChannel <- result
+				select {
+				case outputChannel <- result:
+				case <-ctx.Done():
+				}
 			}
 		}(s)
 	}
-
-	// after everything's finished fetch and lock the semaphore
-	if err := sem1.Acquire(ctx, int64(limit)); err != nil {
-		log.Printf("Failed to acquire semaphore: %v", err)
-	}
 }
 
-func RunTwitterPipeline(searchPhrase string) {
-	statsviz.RegisterDefault()
+func RunTwitterPipeline(searchPhrase string, opts ...Option) {
+	// Parse JSON config for use
+	cfg := config.ParseConfig()
+	configureLogging(cfg)
 
 	// extract search phrase from command line arguments
 	var finalSearchPhrase string
 	if searchPhrase == "" {
 		finalSearchPhrase = "#nft"
-		log.Println("No search phrase provided, using default:", finalSearchPhrase)
+		logger().Info().Str(monitoring.FieldTerm, finalSearchPhrase).Msg("No search phrase provided, using the default")
 	} else {
 		finalSearchPhrase = searchPhrase
-		log.Println("Searching Twitter for:", finalSearchPhrase)
+		logger().Info().Str(monitoring.FieldTerm, finalSearchPhrase).Msg("Searching Twitter")
+	}
+	Run(pipelineFromConfig(cfg, opts...), NewTwitterStreamSource(finalSearchPhrase, cfg))
+}
+
+// RunPipeline runs the stages from config.json (or DefaultStages) over every tweet emitted by src
+func RunPipeline(src Source, opts ...Option) {
+	Run(pipelineFromConfig(config.ParseConfig(), opts...), src)
+}
+
+// RunConfiguredPipeline runs the pipeline using the source stage declared in config.json,
+// falling back to the Twitter filter stream for searchPhrase when there is none.
+// A searchPhrase overrides the "term" of the declared source stage.
+func RunConfiguredPipeline(searchPhrase string, opts ...Option) {
+	cfg := config.ParseConfig()
+	pipeline := pipelineFromConfig(cfg, opts...)
+	if pipeline.Source == nil {
+		RunTwitterPipeline(searchPhrase, opts...)
+		return
+	}
+	if searchPhrase != "" {
+		pipeline.Source.setTerm(searchPhrase)
+		pipeline.Term = searchPhrase
+		logger().Info().Str(monitoring.FieldTerm, searchPhrase).Str(monitoring.FieldStage, pipeline.Source.Name).Msg("Overriding the term of the source stage")
+	}
+	src, err := pipeline.NewSource(cfg)
+	if err != nil {
+		logger().Fatal().Err(err).Msg("Invalid pipeline source")
+	}
+	Run(pipeline, src)
+}
+
+// Option adjusts the pipeline built from config.json before it runs (command line flags, ...)
+type Option func(*Pipeline) error
+
+func pipelineFromConfig(cfg config.Config, opts ...Option) *Pipeline {
+	configureLogging(cfg)
+	pipeline, err := BuildPipeline(cfg.Stages)
+	if err != nil {
+		logger().Fatal().Err(err).Msg("Invalid pipeline stages")
+	}
+	for _, opt := range opts {
+		if err := opt(pipeline); err != nil {
+			logger().Fatal().Err(err).Msg("Invalid pipeline options")
+		}
 	}
+	pipeline.Config = cfg
+	if raw := cfg.General["drain_timeout"]; raw != "" {
+		if pipeline.DrainTimeout, err = time.ParseDuration(raw); err != nil {
+			logger().Fatal().Err(err).Str("drain_timeout", raw).Msg("Invalid drain_timeout")
+		}
+	}
+	return pipeline
+}
+
+// Run runs an already built pipeline over every tweet emitted by src.
+// On SIGINT/SIGTERM the source is stopped and in-flight tweets drain through every stage
+// until the pipeline's drain timeout, a second signal aborts immediately.
+func Run(pipeline *Pipeline, src Source) {
+	statsviz.RegisterDefault()
+	http.Handle("/metrics", promhttp.Handler())
 
 	go func() {
-		log.Println("Navigate to: http://localhost:6070/debug/statsviz/ for metrics")
-		log.Println(http.ListenAndServe("localhost:6070", nil))
+		logger().Info().Msg("Navigate to: http://localhost:6070/debug/statsviz/ for runtime graphs, Prometheus metrics are on /metrics")
+		logger().Error().Err(http.ListenAndServe("localhost:6070", nil)).Msg("Debug server stopped")
 	}()
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	/*
-		readStream, err := producer(ctx, source)
+	if pipeline.Logger == nil {
+		traces, err := monitoring.NewLoggerFromConfig(pipeline.Config)
 		if err != nil {
-			log.Fatal(err)
+			logger().Fatal().Err(err).Msg("Invalid log sink config")
 		}
-	*/
-	// using generator as initial producer (outputs an interface{} channel)
-	sourceChannel := generator(finalSearchPhrase, cfg)
-	errorChannel := make(chan error)
-	// Run lexicon sentiment analysis concurrently with ML Sentiment Analysis
-	// then merge the results with the original document?
+		// delivers the remaining entries once every stage has drained
+		defer traces.Close()
+		pipeline.Logger = traces
+	}
 
-	// Layer 1: Sentiment Analysis
-	layer1OutputChannel := make(chan interface{})
-	go func() {
-		step(ctx, sourceChannel, layer1OutputChannel, errorChannel, analysis.LexiconSentimentAnalysis, "lexiconSentimentAnalysis")
-	}()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stats.reset()
+	started := time.Now()
+	if ts, ok := src.(termSource); ok && pipeline.Term == "" {
+		pipeline.Term = ts.Term()
+	}
+	stats.setTerm(pipeline.Term)
 
-	// Layer 2: DB Upload
-	layer3OutputChannel := make(chan interface{})
+	// the source is the initial producer (outputs an interface{} channel)
+	sourceChannel, err := src.Start(ctx)
+	if err != nil {
+		logger().Fatal().Err(err).Msg("Could not start the source")
+	}
+	defer src.Stop()
+	errorChannel := make(chan error)
 	go func() {
-		step(ctx, layer1OutputChannel, layer3OutputChannel, errorChannel, analysis.FormatAndUpload, "formatAndUpload")
+		// surface source failures (disconnects, read errors) to the sink
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case err := <-src.Errors():
+				select {
+				case errorChannel <- err:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
 	}()
 
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go drainOnSignal(ctx, cancel, signals, src, pipeline.drainTimeout())
+
+	// wire the configured stages between the source and the sink
+	sinkChannel, steps, err := pipeline.start(ctx, countReceived(ctx, sourceChannel), errorChannel)
+	if err != nil {
+		logger().Fatal().Err(err).Msg("Could not start the stages")
+	}
+
+	var forwarding sync.WaitGroup
+	for _, st := range steps {
+		if st.Errors != nil {
+			forwarding.Add(1)
+			go forwardErrors(ctx, st.Errors, errorChannel, &forwarding)
+		}
+	}
+
 	// Sink
-	sink(ctx, cancel, layer3OutputChannel, errorChannel)
+	sink(ctx, sinkChannel, errorChannel)
+	// everything drained (or was aborted), release db clients and other step resources
+	closeStepsCounting(steps, errorChannel, &forwarding)
+	logger().Info().Dur(monitoring.FieldLatency, time.Since(started)).EmbedObject(&stats).Msg("Pipeline finished")
+}
+
+func drainOnSignal(ctx context.Context, cancel context.CancelFunc, signals <-chan os.Signal, src Source, timeout time.Duration) {
+	select {
+	case <-ctx.Done():
+		return
+	case sig := <-signals:
+		logger().Info().Stringer("signal", sig).Dur("deadline", timeout).Msg("Stopping the source and draining in-flight tweets")
+		// closing the source lets every stage finish what it has and close its output in turn
+		src.Stop()
+	}
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	select {
+	case <-ctx.Done():
+	case sig := <-signals:
+		logger().Warn().Stringer("signal", sig).Msg("Signalled again, aborting without draining")
+		cancel()
+	case <-deadline.C:
+		logger().Warn().Dur("deadline", timeout).Msg("Drain deadline exceeded, aborting")
+		cancel()
+	}
 }
 
 func main() {
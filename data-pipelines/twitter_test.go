@@ -0,0 +1,85 @@
+package data_pipelines
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/jmoussa/go-sentitweet/monitoring"
+	"github.com/rs/zerolog"
+)
+
+func TestStepTracesMessages(t *testing.T) {
+	var out bytes.Buffer
+	for _, level := range []monitoring.Level{monitoring.LevelDebug, monitoring.LevelInfo} {
+		out.Reset()
+		logger, err := monitoring.NewLogger(monitoring.NewWriterSink(&out), monitoring.LoggerOptions{Level: level})
+		if err != nil {
+			t.Fatal(err)
+		}
+		in, output, errs := make(chan interface{}, 2), make(chan interface{}, 2), make(chan error, 2)
+		in <- "a"
+		in <- "b"
+		close(in)
+		step[interface{}, interface{}](context.Background(), in, output, errs, func(s interface{}) (interface{}, error) {
+			return s, nil
+		}, 2, "echo", logger)
+		if len(output) != 2 {
+			t.Fatalf("expected 2 outputs, got %d", len(output))
+		}
+		logger.Close()
+
+		var entries []monitoring.Log
+		scanner := bufio.NewScanner(&out)
+		for scanner.Scan() {
+			var entry monitoring.Log
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				t.Fatal(err)
+			}
+			entries = append(entries, entry)
+		}
+		want := 4
+		if level != monitoring.LevelDebug {
+			want = 0
+		}
+		if len(entries) != want {
+			t.Fatalf("%s: expected %d trace entries, got %+v", level, want, entries)
+		}
+		for _, entry := range entries {
+			if entry.Stage != "echo" || (entry.Type != "Start" && entry.Type != "Stop") {
+				t.Fatalf("unexpected trace entry %+v", entry)
+			}
+		}
+	}
+
+	// without a logger nothing is traced
+	in, output := make(chan interface{}, 1), make(chan interface{}, 1)
+	in <- "a"
+	close(in)
+	step[interface{}, interface{}](context.Background(), in, output, make(chan error), func(s interface{}) (interface{}, error) {
+		return s, nil
+	}, 1, "echo", nil)
+	if len(output) != 1 {
+		t.Fatal("expected the message to go through")
+	}
+}
+
+func TestStatsFields(t *testing.T) {
+	var s pipelineStats
+	s.reset()
+	s.count(&s.received, nil)
+	s.count(&s.received, nil)
+	s.count(&s.failed, nil)
+	var buf bytes.Buffer
+	log := zerolog.New(&buf)
+	log.Info().EmbedObject(&s).Msg("Pipeline finished")
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry["received"] != 2.0 || entry["failed"] != 1.0 || entry["stored"] != 0.0 {
+		t.Errorf("expected the counters as fields, got %v", entry)
+	}
+}
@@ -0,0 +1,299 @@
+package db
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jmoussa/go-sentitweet/analysis"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+/*
+Tweet filters. Every condition that is set must hold (AND), the zero TweetFilter matches every tweet.
+Each backend translates the same filter: a single Mongo query, a SQL WHERE clause, or a Go matcher for the memory store.
+*/
+
+type TweetFilter struct {
+	// SearchPhrase matches the tweet text like TextSearch
+	SearchPhrase string `json:"searchPhrase,omitempty"`
+	// DaysBack keeps the tweets created in the last DaysBack days
+	DaysBack int `json:"daysBack,omitempty"`
+	// From (inclusive) and To (exclusive) bound the tweet creation date
+	From time.Time `json:"from,omitempty"`
+	To   time.Time `json:"to,omitempty"`
+	// Scorer is the scorer the compound/label conditions (and the compound sort) use, default vader
+	Scorer      string   `json:"scorer,omitempty"`
+	MinCompound *float64 `json:"minCompound,omitempty"`
+	MaxCompound *float64 `json:"maxCompound,omitempty"`
+	Label       string   `json:"label,omitempty"`
+	// Author is the screen name of the tweet's author, case insensitive, with or without the @
+	Author string `json:"author,omitempty"`
+	// Hashtags must all be used by the tweet, case insensitive, with or without the #
+	Hashtags []string `json:"hashtags,omitempty"`
+	Lang     string   `json:"lang,omitempty"`
+	// IsRetweet, IsQuote and IsReply keep only (true) or leave out (false) that kind of tweet
+	IsRetweet    *bool `json:"isRetweet,omitempty"`
+	IsQuote      *bool `json:"isQuote,omitempty"`
+	IsReply      *bool `json:"isReply,omitempty"`
+	MinFollowers int   `json:"minFollowers,omitempty"`
+	// Term is the tracked term the tweet was collected for
+	Term string `json:"term,omitempty"`
+}
+
+// validate checks the filter and normalizes its scorer, author and hashtags
+func (f *TweetFilter) validate() error {
+	if f.Scorer == "" && (f.MinCompound != nil || f.MaxCompound != nil || f.Label != "") {
+		f.Scorer = defaultSortScorer
+	}
+	if f.Scorer != "" && !scorerNamePattern.MatchString(f.Scorer) {
+		return fmt.Errorf("%w: invalid scorer name %q", ErrInvalidQuery, f.Scorer)
+	}
+	if f.DaysBack < 0 {
+		return fmt.Errorf("%w: daysBack can't be negative", ErrInvalidQuery)
+	}
+	if !f.From.IsZero() && !f.To.IsZero() && !f.From.Before(f.To) {
+		return fmt.Errorf("%w: from must be before to", ErrInvalidQuery)
+	}
+	if f.MinCompound != nil && f.MaxCompound != nil && *f.MinCompound > *f.MaxCompound {
+		return fmt.Errorf("%w: minCompound is above maxCompound", ErrInvalidQuery)
+	}
+	switch f.Label {
+	case "", analysis.LabelPositive, analysis.LabelNegative, analysis.LabelNeutral:
+	default:
+		return fmt.Errorf("%w: unknown label %q", ErrInvalidQuery, f.Label)
+	}
+	if f.MinFollowers < 0 {
+		return fmt.Errorf("%w: minFollowers can't be negative", ErrInvalidQuery)
+	}
+	f.Author = strings.TrimPrefix(strings.TrimSpace(f.Author), "@")
+	hashtags := make([]string, 0, len(f.Hashtags))
+	for _, tag := range f.Hashtags {
+		if tag = strings.TrimPrefix(strings.TrimSpace(tag), "#"); tag != "" {
+			hashtags = append(hashtags, tag)
+		}
+	}
+	f.Hashtags = hashtags
+	return nil
+}
+
+// since is the oldest creation date the filter keeps (the later of From and DaysBack), zero for no bound
+func (f TweetFilter) since() time.Time {
+	since := f.From
+	if f.DaysBack > 0 {
+		if cutoff := time.Now().AddDate(0, 0, -f.DaysBack); cutoff.After(since) {
+			since = cutoff
+		}
+	}
+	return since
+}
+
+// mongoFilter translates every condition but the text search, which needs the text index ($text) or a $regex
+func (f TweetFilter) mongoFilter() bson.M {
+	var and bson.A
+	if since := f.since(); !since.IsZero() {
+		and = append(and, bson.M{"created_at": bson.M{"$gte": since}})
+	}
+	if !f.To.IsZero() {
+		and = append(and, bson.M{"created_at": bson.M{"$lt": f.To}})
+	}
+	score := "scores." + f.Scorer
+	if f.MinCompound != nil {
+		and = append(and, bson.M{score + ".compound": bson.M{"$gte": *f.MinCompound}})
+	}
+	if f.MaxCompound != nil {
+		and = append(and, bson.M{score + ".compound": bson.M{"$lte": *f.MaxCompound}})
+	}
+	if f.Label != "" {
+		and = append(and, bson.M{score + ".label": f.Label})
+	}
+	if f.Author != "" {
+		and = append(and, bson.M{"basetweet.user.screenname": exactInsensitive(f.Author)})
+	}
+	for _, tag := range f.Hashtags {
+		and = append(and, bson.M{"basetweet.entities.hashtags.text": exactInsensitive(tag)})
+	}
+	if f.Lang != "" {
+		and = append(and, bson.M{"basetweet.lang": f.Lang})
+	}
+	if f.IsRetweet != nil {
+		op := "$eq"
+		if *f.IsRetweet {
+			op = "$ne"
+		}
+		and = append(and, bson.M{"basetweet.retweetedstatus": bson.M{op: nil}})
+	}
+	and = append(and, nonZeroID("basetweet.quotedstatusid", f.IsQuote)...)
+	and = append(and, nonZeroID("basetweet.inreplytostatusid", f.IsReply)...)
+	if f.MinFollowers > 0 {
+		and = append(and, bson.M{"basetweet.user.followerscount": bson.M{"$gte": f.MinFollowers}})
+	}
+	if f.Term != "" {
+		and = append(and, bson.M{"term": f.Term})
+	}
+	if len(and) == 0 {
+		return bson.M{}
+	}
+	return bson.M{"$and": and}
+}
+
+// phraseRegex is the $regex fallback of a search phrase without the text index: the phrase as a literal,
+// case insensitive, like the $text phrase search and the sqlite FTS5 phrase
+func phraseRegex(searchPhrase string) bson.M {
+	return bson.M{"$regex": regexp.QuoteMeta(searchPhrase), "$options": "i"}
+}
+
+func exactInsensitive(value string) bson.M {
+	return bson.M{"$regex": "^" + regexp.QuoteMeta(value) + "$", "$options": "i"}
+}
+
+func nonZeroID(field string, want *bool) bson.A {
+	if want == nil {
+		return nil
+	}
+	if *want {
+		return bson.A{bson.M{field: bson.M{"$gt": 0}}}
+	}
+	return bson.A{bson.M{field: bson.M{"$not": bson.M{"$gt": 0}}}}
+}
+
+// sqlFilter translates every condition but the text search (FTS5) into a WHERE clause over tweets t
+func (f TweetFilter) sqlFilter() ([]string, []interface{}) {
+	var (
+		where []string
+		args  []interface{}
+	)
+	if since := f.since(); !since.IsZero() {
+		where = append(where, `t.created_at >= ?`)
+		args = append(args, since.Unix())
+	}
+	if !f.To.IsZero() {
+		where = append(where, `t.created_at < ?`)
+		args = append(args, f.To.Unix())
+	}
+	// f.Scorer is validated, safe to use in the JSON path
+	score := `'$.` + f.Scorer
+	if f.MinCompound != nil {
+		where = append(where, `json_extract(t.scores, `+score+`.compound') >= ?`)
+		args = append(args, *f.MinCompound)
+	}
+	if f.MaxCompound != nil {
+		where = append(where, `json_extract(t.scores, `+score+`.compound') <= ?`)
+		args = append(args, *f.MaxCompound)
+	}
+	if f.Label != "" {
+		where = append(where, `json_extract(t.scores, `+score+`.label') = ?`)
+		args = append(args, f.Label)
+	}
+	if f.Author != "" {
+		where = append(where, `lower(json_extract(t.tweet, '$.user.screen_name')) = lower(?)`)
+		args = append(args, f.Author)
+	}
+	for _, tag := range f.Hashtags {
+		where = append(where, `EXISTS (SELECT 1 FROM json_each(t.tweet, '$.entities.hashtags') WHERE lower(json_extract(value, '$.text')) = lower(?))`)
+		args = append(args, tag)
+	}
+	if f.Lang != "" {
+		where = append(where, `json_extract(t.tweet, '$.lang') = ?`)
+		args = append(args, f.Lang)
+	}
+	if f.IsRetweet != nil {
+		if *f.IsRetweet {
+			where = append(where, `json_type(t.tweet, '$.retweeted_status') = 'object'`)
+		} else {
+			where = append(where, `COALESCE(json_type(t.tweet, '$.retweeted_status'), 'null') = 'null'`)
+		}
+	}
+	where = append(where, nonZeroJSON("$.quoted_status_id", f.IsQuote)...)
+	where = append(where, nonZeroJSON("$.in_reply_to_status_id", f.IsReply)...)
+	if f.MinFollowers > 0 {
+		where = append(where, `COALESCE(json_extract(t.tweet, '$.user.followers_count'), 0) >= ?`)
+		args = append(args, f.MinFollowers)
+	}
+	if f.Term != "" {
+		where = append(where, `t.term = ?`)
+		args = append(args, f.Term)
+	}
+	return where, args
+}
+
+func nonZeroJSON(path string, want *bool) []string {
+	if want == nil {
+		return nil
+	}
+	if *want {
+		return []string{`COALESCE(json_extract(t.tweet, '` + path + `'), 0) > 0`}
+	}
+	return []string{`COALESCE(json_extract(t.tweet, '` + path + `'), 0) <= 0`}
+}
+
+// match is the Go version of the filter, for the memory store. pattern is the compiled SearchPhrase, nil for none
+func (f TweetFilter) match(msg analysis.TweetWithScoreMessage, pattern *regexp.Regexp) bool {
+	tweet := msg.BaseTweet
+	if pattern != nil && !pattern.MatchString(tweet.Text) {
+		return false
+	}
+	if since := f.since(); !since.IsZero() && (msg.CreatedAt.IsZero() || msg.CreatedAt.Before(since)) {
+		return false
+	}
+	if !f.To.IsZero() && (msg.CreatedAt.IsZero() || !msg.CreatedAt.Before(f.To)) {
+		return false
+	}
+	if f.MinCompound != nil || f.MaxCompound != nil || f.Label != "" {
+		score, ok := msg.Scores[f.Scorer]
+		if !ok ||
+			(f.MinCompound != nil && score.Compound < *f.MinCompound) ||
+			(f.MaxCompound != nil && score.Compound > *f.MaxCompound) ||
+			(f.Label != "" && score.Label != f.Label) {
+			return false
+		}
+	}
+	if f.Author != "" && (tweet.User == nil || !strings.EqualFold(tweet.User.ScreenName, f.Author)) {
+		return false
+	}
+	for _, tag := range f.Hashtags {
+		if !hasHashtag(msg, tag) {
+			return false
+		}
+	}
+	if f.Lang != "" && tweet.Lang != f.Lang {
+		return false
+	}
+	if f.IsRetweet != nil && *f.IsRetweet != (tweet.RetweetedStatus != nil) {
+		return false
+	}
+	if f.IsQuote != nil && *f.IsQuote != (tweet.QuotedStatusID > 0) {
+		return false
+	}
+	if f.IsReply != nil && *f.IsReply != (tweet.InReplyToStatusID > 0) {
+		return false
+	}
+	if f.MinFollowers > 0 && (tweet.User == nil || tweet.User.FollowersCount < f.MinFollowers) {
+		return false
+	}
+	return f.Term == "" || msg.Term == f.Term
+}
+
+// Matcher validates the filter and returns its Go matcher, to filter tweets that don't come from a store (live streams)
+func (f TweetFilter) Matcher() (func(analysis.TweetWithScoreMessage) bool, error) {
+	if err := f.validate(); err != nil {
+		return nil, err
+	}
+	pattern := searchPattern(f.SearchPhrase)
+	return func(msg analysis.TweetWithScoreMessage) bool {
+		return msg.BaseTweet != nil && f.match(msg, pattern)
+	}, nil
+}
+
+func hasHashtag(msg analysis.TweetWithScoreMessage, tag string) bool {
+	if msg.BaseTweet.Entities == nil {
+		return false
+	}
+	for _, hashtag := range msg.BaseTweet.Entities.Hashtags {
+		if strings.EqualFold(hashtag.Text, tag) {
+			return true
+		}
+	}
+	return false
+}
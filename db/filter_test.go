@@ -0,0 +1,186 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dghubble/go-twitter/twitter"
+	"github.com/jmoussa/go-sentitweet/analysis"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func filterFixtures(now time.Time) []analysis.TweetWithScoreMessage {
+	gopher := &twitter.User{ScreenName: "Gopher", FollowersCount: 5000}
+	newbie := &twitter.User{ScreenName: "newbie", FollowersCount: 10}
+	tags := func(tags ...string) *twitter.Entities {
+		entities := &twitter.Entities{}
+		for _, tag := range tags {
+			entities.Hashtags = append(entities.Hashtags, twitter.HashtagEntity{Text: tag})
+		}
+		return entities
+	}
+	msgs := []analysis.TweetWithScoreMessage{
+		scoredTweet(1, "I love #golang and #rust", now, "vader", 0.8),
+		scoredTweet(2, "RT golang is slow", now.AddDate(0, 0, -1), "vader", -0.5),
+		scoredTweet(3, "@gopher thanks", now.AddDate(0, 0, -3), "vader", 0.2),
+		scoredTweet(4, "bonjour #golang", now.AddDate(0, 0, -20), "vader", 0),
+	}
+	msgs[0].BaseTweet.User, msgs[0].BaseTweet.Entities, msgs[0].BaseTweet.Lang = gopher, tags("GoLang", "rust"), "en"
+	msgs[1].BaseTweet.User, msgs[1].BaseTweet.Lang = newbie, "en"
+	msgs[1].BaseTweet.RetweetedStatus = &twitter.Tweet{ID: 100, Text: "golang is slow"}
+	msgs[2].BaseTweet.User, msgs[2].BaseTweet.Lang = newbie, "en"
+	msgs[2].BaseTweet.InReplyToStatusID, msgs[2].BaseTweet.QuotedStatusID = 1, 1
+	msgs[3].BaseTweet.User, msgs[3].BaseTweet.Entities, msgs[3].BaseTweet.Lang = gopher, tags("golang"), "fr"
+	msgs[0].Term, msgs[1].Term, msgs[2].Term = "#golang", "#golang", "#rust"
+	return msgs
+}
+
+func testFilters(t *testing.T, store TweetStore) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+	for i, err := range store.UpsertMany(ctx, filterFixtures(now)) {
+		if err != nil {
+			t.Fatalf("UpsertMany[%d]: %v", i, err)
+		}
+	}
+	yes, no := true, false
+	low, high := 0.1, 0.5
+	tests := []struct {
+		name   string
+		filter TweetFilter
+		want   []int64
+	}{
+		{"everything", TweetFilter{}, []int64{1, 2, 3, 4}},
+		{"text and days back", TweetFilter{SearchPhrase: "golang", DaysBack: 5}, []int64{1, 2}},
+		{"date range", TweetFilter{From: now.AddDate(0, 0, -4), To: now.Add(-time.Hour)}, []int64{2, 3}},
+		{"compound range", TweetFilter{MinCompound: &low, MaxCompound: &high}, []int64{3}},
+		{"label", TweetFilter{Label: analysis.LabelPositive}, []int64{1, 3}},
+		{"author", TweetFilter{Author: "@gopher"}, []int64{1, 4}},
+		{"hashtags", TweetFilter{Hashtags: []string{"#golang", "Rust"}}, []int64{1}},
+		{"lang", TweetFilter{Lang: "fr"}, []int64{4}},
+		{"retweets", TweetFilter{IsRetweet: &yes}, []int64{2}},
+		{"no retweets", TweetFilter{IsRetweet: &no}, []int64{1, 3, 4}},
+		{"quotes", TweetFilter{IsQuote: &yes}, []int64{3}},
+		{"replies", TweetFilter{IsReply: &yes}, []int64{3}},
+		{"no replies", TweetFilter{IsReply: &no}, []int64{1, 2, 4}},
+		{"followers", TweetFilter{MinFollowers: 1000}, []int64{1, 4}},
+		{"term", TweetFilter{Term: "#golang"}, []int64{1, 2}},
+		{"combined", TweetFilter{Author: "gopher", Hashtags: []string{"golang"}, Lang: "en", Label: analysis.LabelPositive}, []int64{1}},
+	}
+	for _, tt := range tests {
+		page, err := store.Find(ctx, TweetQuery{TweetFilter: tt.filter})
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		var got []int64
+		// pages are newest first, the fixtures are numbered from newest to oldest
+		for _, tweet := range page.Tweets {
+			got = append(got, tweet.BaseTweet.ID)
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: expected tweets %v, got %v", tt.name, tt.want, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: expected tweets %v, got %v", tt.name, tt.want, got)
+				break
+			}
+		}
+	}
+
+	bad := []TweetFilter{
+		{Label: "ecstatic"},
+		{From: now, To: now.AddDate(0, 0, -1)},
+		{MinCompound: &high, MaxCompound: &low},
+		{Scorer: "vader.compound"},
+	}
+	for _, filter := range bad {
+		if _, err := store.Find(ctx, TweetQuery{TweetFilter: filter}); err == nil {
+			t.Errorf("expected an invalid query error for %+v", filter)
+		}
+	}
+}
+
+func TestMemoryStoreFilters(t *testing.T) {
+	testFilters(t, NewMemoryStore())
+}
+
+func TestSQLiteStoreFilters(t *testing.T) {
+	testFilters(t, newTestSQLiteStore(t))
+}
+
+func TestMongoFilter(t *testing.T) {
+	yes := true
+	f := TweetFilter{Author: "@gopher", Hashtags: []string{"#golang"}, IsRetweet: &yes, Label: analysis.LabelNegative, Term: "#golang"}
+	if err := f.validate(); err != nil {
+		t.Fatal(err)
+	}
+	and, _ := f.mongoFilter()["$and"].(bson.A)
+	if len(and) != 5 {
+		t.Fatalf("expected 5 conditions, got %v", and)
+	}
+	if author := and[1].(bson.M)["basetweet.user.screenname"].(bson.M); author["$regex"] != "^gopher$" {
+		t.Errorf("unexpected author condition %v", author)
+	}
+	if label := and[0].(bson.M)["scores.vader.label"]; label != analysis.LabelNegative {
+		t.Errorf("expected the label of the default scorer, got %v", and[0])
+	}
+	if len(TweetFilter{}.mongoFilter()) != 0 {
+		t.Error("expected an empty filter to match everything")
+	}
+}
+
+func TestFilterMatcher(t *testing.T) {
+	msgs := filterFixtures(time.Now().UTC())
+	low := 0.1
+	tests := []struct {
+		filter TweetFilter
+		want   []int64
+	}{
+		{TweetFilter{}, []int64{1, 2, 3, 4}},
+		{TweetFilter{Term: "#golang", MinCompound: &low}, []int64{1}},
+		{TweetFilter{SearchPhrase: "golang", Author: "@Gopher"}, []int64{1, 4}},
+		{TweetFilter{Hashtags: []string{"#golang"}, Lang: "fr"}, []int64{4}},
+	}
+	for _, tt := range tests {
+		match, err := tt.filter.Matcher()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []int64
+		for _, msg := range msgs {
+			if match(msg) {
+				got = append(got, msg.BaseTweet.ID)
+			}
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%+v: expected tweets %v, got %v", tt.filter, tt.want, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%+v: expected tweets %v, got %v", tt.filter, tt.want, got)
+				break
+			}
+		}
+	}
+	if match, _ := (TweetFilter{}).Matcher(); match(analysis.TweetWithScoreMessage{}) {
+		t.Error("expected a message without tweet not to match")
+	}
+	for _, filter := range []TweetFilter{{Label: "ecstatic"}, {DaysBack: -1}} {
+		if _, err := filter.Matcher(); err == nil {
+			t.Errorf("expected an invalid query error for %+v", filter)
+		}
+	}
+	// search phrases are literal, not regular expressions
+	match, err := (TweetFilter{SearchPhrase: "(C++"}).Matcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !match(scoredTweet(1, "learning (c++ next", time.Now(), "vader", 0)) || match(scoredTweet(2, "learning c next", time.Now(), "vader", 0)) {
+		t.Error("expected the phrase to match literally, whatever the case")
+	}
+}
@@ -0,0 +1,156 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+Indexes of the tweets collection (`tw db indexes ensure|list|drop`).
+NewMongoStore ensures them at startup unless mongo_ensure_indexes is "false". Building them on a large
+existing collection takes a while, run `tw db indexes ensure` once before deploying instead.
+*/
+
+// TweetIndexes are the indexes the MongoStore queries rely on
+var TweetIndexes = []mongo.IndexModel{
+	{
+		// upserts and Get look tweets up by ID, unique so concurrent upserts can't duplicate a tweet
+		Keys:    bson.D{{Key: "basetweet.id", Value: 1}},
+		Options: options.Index().SetName("basetweet_id").SetUnique(true),
+	},
+	{
+		Keys:    bson.D{{Key: "created_at", Value: -1}},
+		Options: options.Index().SetName("created_at"),
+	},
+	{
+		Keys:    bson.D{{Key: "term", Value: 1}, {Key: "created_at", Value: -1}},
+		Options: options.Index().SetName("term_created_at"),
+	},
+	{
+		// backs TextSearch, relevance ranked
+		Keys:    bson.D{{Key: "basetweet.text", Value: "text"}},
+		Options: options.Index().SetName("basetweet_text").SetDefaultLanguage("english"),
+	},
+}
+
+// duplicateSample bounds the duplicate tweet IDs reported when the unique index can't be built
+const duplicateSample = 10
+
+// IndexError is the failure to build one of TweetIndexes
+type IndexError struct {
+	Name string
+	Err  error
+	// DuplicateIDs are (some of) the tweet IDs stored more than once, when they kept the unique index from being built
+	DuplicateIDs []int64
+}
+
+func (e *IndexError) Error() string {
+	if len(e.DuplicateIDs) > 0 {
+		return fmt.Sprintf("index %s: tweets %v (and maybe others) are stored more than once, keep one document per basetweet.id and run `tw db indexes ensure` again: %v",
+			e.Name, e.DuplicateIDs, e.Err)
+	}
+	return fmt.Sprintf("index %s: %v", e.Name, e.Err)
+}
+
+func (e *IndexError) Unwrap() error {
+	return e.Err
+}
+
+// IndexErrors are the indexes EnsureIndexes couldn't build, the others are built regardless
+type IndexErrors []*IndexError
+
+func (e IndexErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// EnsureIndexes creates the missing TweetIndexes one at a time, existing ones are left alone.
+// An index that fails (IndexErrors) doesn't keep the others from being built.
+func EnsureIndexes(ctx context.Context, tweets *mongo.Collection) ([]string, error) {
+	var (
+		names []string
+		errs  IndexErrors
+	)
+	for _, index := range TweetIndexes {
+		name, err := tweets.Indexes().CreateOne(ctx, index)
+		if err != nil {
+			indexErr := &IndexError{Name: *index.Options.Name, Err: err}
+			if index.Options.Unique != nil && *index.Options.Unique && mongo.IsDuplicateKeyError(err) {
+				indexErr.DuplicateIDs, _ = duplicateTweetIDs(ctx, tweets, duplicateSample)
+			}
+			errs = append(errs, indexErr)
+			continue
+		}
+		names = append(names, name)
+	}
+	if len(errs) > 0 {
+		return names, fmt.Errorf("could not ensure the indexes of %s: %w", tweets.Name(), errs)
+	}
+	return names, nil
+}
+
+// duplicateTweetIDs finds up to limit tweet IDs stored in more than one document
+func duplicateTweetIDs(ctx context.Context, tweets *mongo.Collection, limit int) ([]int64, error) {
+	cursor, err := tweets.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{"_id": "$basetweet.id", "count": bson.M{"$sum": 1}}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+		{{Key: "$limit", Value: limit}},
+	}, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, err
+	}
+	var groups []struct {
+		ID int64 `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+	ids := make([]int64, len(groups))
+	for i, group := range groups {
+		ids[i] = group.ID
+	}
+	return ids, nil
+}
+
+// ListIndexes describes the indexes of the collection as stored by mongo (name, key, unique, ...)
+func ListIndexes(ctx context.Context, tweets *mongo.Collection) ([]bson.M, error) {
+	cursor, err := tweets.Indexes().List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not list the indexes of %s: %w", tweets.Name(), err)
+	}
+	var indexes []bson.M
+	if err := cursor.All(ctx, &indexes); err != nil {
+		return nil, err
+	}
+	return indexes, nil
+}
+
+// DropIndexes drops the named indexes, or every one of TweetIndexes when names is empty
+func DropIndexes(ctx context.Context, tweets *mongo.Collection, names ...string) error {
+	if len(names) == 0 {
+		for _, index := range TweetIndexes {
+			names = append(names, *index.Options.Name)
+		}
+	}
+	for _, name := range names {
+		if _, err := tweets.Indexes().DropOne(ctx, name); err != nil && !isIndexNotFound(err) {
+			return fmt.Errorf("could not drop index %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func isIndexNotFound(err error) bool {
+	var cmdErr mongo.CommandError
+	return errors.As(err, &cmdErr) && cmdErr.Code == 27
+}
@@ -0,0 +1,38 @@
+package db
+
+import (
+	"errors"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestEnsureIndexesBuildsEachIndex(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("duplicates", func(mt *mtest.T) {
+		// the unique index fails on duplicates, which are then looked up, the other indexes are still built
+		responses := []bson.D{
+			mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 11000, Message: "E11000 duplicate key error", Name: "DuplicateKey"}),
+			mtest.CreateCursorResponse(0, "db.tweets", mtest.FirstBatch, bson.D{{Key: "_id", Value: int64(42)}, {Key: "count", Value: 2}}),
+		}
+		for range TweetIndexes[1:] {
+			responses = append(responses, mtest.CreateSuccessResponse())
+		}
+		mt.AddMockResponses(responses...)
+
+		names, err := EnsureIndexes(mtest.Background, mt.Coll)
+		if len(names) != len(TweetIndexes)-1 {
+			t.Errorf("expected the other indexes to be built, got %v", names)
+		}
+		var errs IndexErrors
+		if !errors.As(err, &errs) || len(errs) != 1 {
+			t.Fatalf("expected one IndexError, got %v", err)
+		}
+		if errs[0].Name != "basetweet_id" || len(errs[0].DuplicateIDs) != 1 || errs[0].DuplicateIDs[0] != 42 {
+			t.Errorf("expected the duplicate tweet 42 to be reported, got %+v", errs[0])
+		}
+	})
+}
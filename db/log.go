@@ -0,0 +1,55 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jmoussa/go-sentitweet/monitoring"
+	"github.com/rs/zerolog"
+)
+
+// logger returns the db logger, tagged with the request ID when ctx serves an API request
+func logger(ctx context.Context) *zerolog.Logger {
+	return monitoring.Ctx(ctx, "db")
+}
+
+// observe times a storage operation, the returned func records its latency (and logs it with the error err points to)
+// once it is done:
+//
+//	defer observe(ctx, "mongo", "find")(&err)
+func observe(ctx context.Context, backend, op string) func(*error) {
+	started := time.Now()
+	return func(err *error) {
+		elapsed := time.Since(started)
+		outcome := "ok"
+		var e *zerolog.Event
+		switch {
+		case *err == nil:
+			e = logger(ctx).Debug()
+		case errors.Is(*err, ErrNotFound) || errors.Is(*err, ErrInvalidQuery):
+			// the caller's mistake rather than the storage's
+			e = logger(ctx).Debug().Err(*err)
+		default:
+			outcome = "error"
+			e = logger(ctx).Warn().Err(*err)
+		}
+		monitoring.StorageDuration.WithLabelValues(backend, op, outcome).Observe(elapsed.Seconds())
+		e.Str("backend", backend).Str("op", op).Dur(monitoring.FieldLatency, elapsed).Msg("Storage operation")
+	}
+}
+
+// observeBatch is observe for the operations returning an error per tweet, it logs the first one
+func observeBatch(ctx context.Context, backend, op string) func(*[]error) {
+	done := observe(ctx, backend, op)
+	return func(errs *[]error) {
+		var err error
+		for _, e := range *errs {
+			if e != nil {
+				err = e
+				break
+			}
+		}
+		done(&err)
+	}
+}
@@ -0,0 +1,189 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jmoussa/go-sentitweet/analysis"
+)
+
+/*
+In-memory TweetStore, for tests and for running the API/pipeline without a database.
+It mirrors the Mongo store's semantics: upserts replace the tweet and merge the scores.
+*/
+
+type MemoryStore struct {
+	mu     sync.RWMutex
+	tweets map[int64]analysis.TweetWithScoreMessage
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{tweets: map[int64]analysis.TweetWithScoreMessage{}}
+}
+
+func (m *MemoryStore) UpsertMany(ctx context.Context, msgs []analysis.TweetWithScoreMessage) []error {
+	now := time.Now().UTC()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	errs := make([]error, len(msgs))
+	for i, msg := range msgs {
+		if msg.BaseTweet == nil {
+			errs[i] = fmt.Errorf("can't store a message without a tweet")
+			continue
+		}
+		stored, exists := m.tweets[msg.BaseTweet.ID]
+		stored.BaseTweet = msg.BaseTweet
+		stored.Merge(msg)
+		if createdAt, err := msg.BaseTweet.CreatedAtTime(); err == nil {
+			stored.CreatedAt = createdAt.UTC()
+		}
+		if !exists {
+			stored.IngestedAt = now
+		}
+		m.tweets[msg.BaseTweet.ID] = stored
+	}
+	return errs
+}
+
+func (m *MemoryStore) Get(ctx context.Context, id int64) (analysis.TweetWithScoreMessage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	tweet, ok := m.tweets[id]
+	if !ok {
+		return analysis.TweetWithScoreMessage{}, ErrNotFound
+	}
+	return copyMessage(tweet), nil
+}
+
+func (m *MemoryStore) TextSearch(ctx context.Context, searchPhrase string) ([]analysis.TweetWithScoreMessage, error) {
+	pattern := searchPattern(searchPhrase)
+	if pattern == nil {
+		return m.filter(func(analysis.TweetWithScoreMessage) bool { return true }), nil
+	}
+	return m.filter(func(tweet analysis.TweetWithScoreMessage) bool {
+		return pattern.MatchString(tweet.BaseTweet.Text)
+	}), nil
+}
+
+func (m *MemoryStore) FindRecent(ctx context.Context, daysBack int) ([]analysis.TweetWithScoreMessage, error) {
+	cutoff := time.Now().AddDate(0, 0, -daysBack)
+	tweets := m.filter(func(tweet analysis.TweetWithScoreMessage) bool {
+		return !tweet.CreatedAt.IsZero() && !tweet.CreatedAt.Before(cutoff)
+	})
+	sort.Slice(tweets, func(i, j int) bool {
+		return tweets[i].CreatedAt.After(tweets[j].CreatedAt)
+	})
+	return tweets, nil
+}
+
+func (m *MemoryStore) Find(ctx context.Context, q TweetQuery) (TweetPage, error) {
+	cursor, err := q.normalize()
+	if err != nil {
+		return TweetPage{}, err
+	}
+	pattern := searchPattern(q.SearchPhrase)
+	tweets := m.filter(func(tweet analysis.TweetWithScoreMessage) bool {
+		if q.Sort == SortCompound {
+			if _, ok := tweet.Scores[q.Scorer]; !ok {
+				return false
+			}
+		}
+		return q.match(tweet, pattern) && cursor.after(tweet)
+	})
+	// descending by the sort key then ID, the order cursor.after pages through
+	sort.Slice(tweets, func(i, j int) bool {
+		a, b := tweets[i], tweets[j]
+		if q.Sort == SortCompound {
+			if ca, cb := a.Scores[q.Scorer].Compound, b.Scores[q.Scorer].Compound; ca != cb {
+				return ca > cb
+			}
+		} else if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.After(b.CreatedAt)
+		}
+		return a.BaseTweet.ID > b.BaseTweet.ID
+	})
+	if len(tweets) > q.Limit+1 {
+		tweets = tweets[:q.Limit+1]
+	}
+	return q.page(tweets)
+}
+
+func (m *MemoryStore) TimeSeries(ctx context.Context, q TimeSeriesQuery) ([]SentimentBucket, error) {
+	if err := q.normalize(); err != nil {
+		return nil, err
+	}
+	pattern := searchPattern(q.SearchPhrase)
+	buckets := newBucketizer(q.Bucket)
+	for _, tweet := range m.filter(func(tweet analysis.TweetWithScoreMessage) bool { return q.match(tweet, pattern) }) {
+		if score, ok := tweet.Scores[q.Scorer]; ok && !tweet.CreatedAt.IsZero() {
+			buckets.add(scoredPoint{CreatedAt: tweet.CreatedAt, Compound: score.Compound, Label: score.Label})
+		}
+	}
+	return buckets.result(), nil
+}
+
+func (m *MemoryStore) Aggregate(ctx context.Context, scorer string) (ScoreSummary, error) {
+	summary := ScoreSummary{Scorer: scorer}
+	var total float64
+	for _, tweet := range m.filter(func(analysis.TweetWithScoreMessage) bool { return true }) {
+		score, ok := tweet.Scores[scorer]
+		if !ok {
+			continue
+		}
+		summary.Count++
+		total += score.Compound
+		switch score.Label {
+		case analysis.LabelPositive:
+			summary.Positive++
+		case analysis.LabelNegative:
+			summary.Negative++
+		case analysis.LabelNeutral:
+			summary.Neutral++
+		}
+	}
+	if summary.Count > 0 {
+		summary.MeanCompound = total / float64(summary.Count)
+	}
+	return summary, nil
+}
+
+func (m *MemoryStore) Close(ctx context.Context) error {
+	return nil
+}
+
+// searchPattern matches a search phrase like the $regex fallback of the mongo store (phraseRegex), nil for no phrase
+func searchPattern(searchPhrase string) *regexp.Regexp {
+	if searchPhrase == "" {
+		return nil
+	}
+	return regexp.MustCompile("(?i)" + regexp.QuoteMeta(searchPhrase))
+}
+
+// filter returns copies of the matching tweets sorted by ID so results are stable
+func (m *MemoryStore) filter(match func(analysis.TweetWithScoreMessage) bool) []analysis.TweetWithScoreMessage {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var tweets []analysis.TweetWithScoreMessage
+	for _, tweet := range m.tweets {
+		if match(tweet) {
+			tweets = append(tweets, copyMessage(tweet))
+		}
+	}
+	sort.Slice(tweets, func(i, j int) bool {
+		return tweets[i].BaseTweet.ID < tweets[j].BaseTweet.ID
+	})
+	return tweets
+}
+
+func copyMessage(msg analysis.TweetWithScoreMessage) analysis.TweetWithScoreMessage {
+	scores := make(map[string]analysis.SentimentResult, len(msg.Scores))
+	for k, v := range msg.Scores {
+		scores[k] = v
+	}
+	msg.Scores = scores
+	return msg
+}
@@ -0,0 +1,334 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jmoussa/go-sentitweet/analysis"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+Versioned schema migrations of the tweets collection (`tw db migrate up|down|status`).
+Applied versions are recorded in the schema_migrations collection of the same database. A migration is
+marked running before it starts and applied once it finishes, so an interrupted one is simply run again:
+every migration has to be idempotent and work through the collection in batches.
+*/
+
+const (
+	migrationsCollection = "schema_migrations"
+	migrationBatchSize   = 1000
+
+	MigrationPending = "pending"
+	MigrationRunning = "running"
+	MigrationApplied = "applied"
+)
+
+// Migration moves the tweets collection from version-1 to Version (Up) and back (Down, nil when irreversible)
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(ctx context.Context, tweets *mongo.Collection) error
+	Down    func(ctx context.Context, tweets *mongo.Collection) error
+}
+
+type MigrationStatus struct {
+	Migration
+	State     string
+	UpdatedAt time.Time
+}
+
+type migrationRecord struct {
+	Version   int       `bson:"_id"`
+	Name      string    `bson:"name"`
+	State     string    `bson:"state"`
+	UpdatedAt time.Time `bson:"updated_at"`
+}
+
+var migrations = map[int]Migration{}
+
+func RegisterMigration(m Migration) {
+	if m.Version < 1 || m.Up == nil {
+		panic(fmt.Sprintf("migration %d %q needs a positive version and an Up function", m.Version, m.Name))
+	}
+	if _, exists := migrations[m.Version]; exists {
+		panic(fmt.Sprintf("migration %d registered twice", m.Version))
+	}
+	migrations[m.Version] = m
+}
+
+// Migrations lists the registered migrations by version
+func Migrations() []Migration {
+	sorted := make([]Migration, 0, len(migrations))
+	for _, m := range migrations {
+		sorted = append(sorted, m)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	return sorted
+}
+
+func init() {
+	RegisterMigration(Migration{
+		Version: 1,
+		Name:    "native_dates",
+		Up: func(ctx context.Context, tweets *mongo.Collection) error {
+			_, err := backfillDates(ctx, tweets, migrationBatchSize)
+			return err
+		},
+		Down: func(ctx context.Context, tweets *mongo.Collection) error {
+			_, err := tweets.UpdateMany(ctx, bson.M{}, bson.M{"$unset": bson.M{"created_at": "", "ingested_at": ""}})
+			return err
+		},
+	})
+	RegisterMigration(Migration{
+		Version: 2,
+		Name:    "typed_scores",
+		Up:      typedScoresUp,
+		Down:    typedScoresDown,
+	})
+}
+
+// Migrator applies the registered migrations to a tweets collection
+type Migrator struct {
+	tweets  *mongo.Collection
+	records *mongo.Collection
+}
+
+func NewMigrator(tweets *mongo.Collection) *Migrator {
+	return &Migrator{tweets: tweets, records: tweets.Database().Collection(migrationsCollection)}
+}
+
+// Status reports the state of every registered migration, by version
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	cursor, err := m.records.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %w", migrationsCollection, err)
+	}
+	var records []migrationRecord
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, fmt.Errorf("could not read %s: %w", migrationsCollection, err)
+	}
+	return migrationStatuses(Migrations(), records), nil
+}
+
+// Up applies the pending (or interrupted) migrations up to version target, every one of them when target is 0
+func (m *Migrator) Up(ctx context.Context, target int) error {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+	for _, migration := range planUp(statuses, target) {
+		logger(ctx).Info().Int("version", migration.Version).Str("migration", migration.Name).Msg("Applying migration")
+		if err := m.record(ctx, migration, MigrationRunning); err != nil {
+			return err
+		}
+		if err := migration.Up(ctx, m.tweets); err != nil {
+			return fmt.Errorf("migration %d %s failed, run it again to resume: %w", migration.Version, migration.Name, err)
+		}
+		if err := m.record(ctx, migration, MigrationApplied); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Down reverts the applied (or interrupted) migrations above version target, newest first
+func (m *Migrator) Down(ctx context.Context, target int) error {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+	plan, err := planDown(statuses, target)
+	if err != nil {
+		return err
+	}
+	for _, migration := range plan {
+		logger(ctx).Info().Int("version", migration.Version).Str("migration", migration.Name).Msg("Reverting migration")
+		// still running until the down migration completes, so an interrupted revert can be resumed either way
+		if err := m.record(ctx, migration, MigrationRunning); err != nil {
+			return err
+		}
+		if err := migration.Down(ctx, m.tweets); err != nil {
+			return fmt.Errorf("reverting migration %d %s failed: %w", migration.Version, migration.Name, err)
+		}
+		if _, err := m.records.DeleteOne(ctx, bson.M{"_id": migration.Version}); err != nil {
+			return fmt.Errorf("could not record migration %d as reverted: %w", migration.Version, err)
+		}
+	}
+	return nil
+}
+
+func (m *Migrator) record(ctx context.Context, migration Migration, state string) error {
+	record := migrationRecord{Version: migration.Version, Name: migration.Name, State: state, UpdatedAt: time.Now().UTC()}
+	_, err := m.records.ReplaceOne(ctx, bson.M{"_id": migration.Version}, record, options.Replace().SetUpsert(true))
+	if err != nil {
+		return fmt.Errorf("could not record migration %d as %s: %w", migration.Version, state, err)
+	}
+	return nil
+}
+
+func migrationStatuses(registered []Migration, records []migrationRecord) []MigrationStatus {
+	byVersion := make(map[int]migrationRecord, len(records))
+	for _, record := range records {
+		byVersion[record.Version] = record
+	}
+	statuses := make([]MigrationStatus, len(registered))
+	for i, migration := range registered {
+		statuses[i] = MigrationStatus{Migration: migration, State: MigrationPending}
+		if record, ok := byVersion[migration.Version]; ok {
+			statuses[i].State = record.State
+			statuses[i].UpdatedAt = record.UpdatedAt
+		}
+	}
+	return statuses
+}
+
+func planUp(statuses []MigrationStatus, target int) []Migration {
+	var plan []Migration
+	for _, status := range statuses {
+		if target > 0 && status.Version > target {
+			break
+		}
+		if status.State != MigrationApplied {
+			plan = append(plan, status.Migration)
+		}
+	}
+	return plan
+}
+
+func planDown(statuses []MigrationStatus, target int) ([]Migration, error) {
+	var plan []Migration
+	for i := len(statuses) - 1; i >= 0 && statuses[i].Version > target; i-- {
+		status := statuses[i]
+		if status.State == MigrationPending {
+			continue
+		}
+		if status.Down == nil {
+			return nil, fmt.Errorf("migration %d %s can't be reverted", status.Version, status.Name)
+		}
+		plan = append(plan, status.Migration)
+	}
+	return plan, nil
+}
+
+// legacy score shapes: the original pipeline stored `lexicon` (VADER polarity map) and `imdb_ml_model`
+// (predicted class) at the top level, later versions stored the same values under `scores`
+var legacyScoreFields = []string{"lexicon", "imdb_ml_model", "scores.lexicon", "scores.imdb_ml_model"}
+
+type legacyScoresDoc struct {
+	Lexicon map[string]float64 `bson:"lexicon"`
+	IMDB    *int               `bson:"imdb_ml_model"`
+	Scores  struct {
+		Lexicon map[string]float64 `bson:"lexicon"`
+		IMDB    *int               `bson:"imdb_ml_model"`
+		Vader   bson.Raw           `bson:"vader"`
+		Imdb    bson.Raw           `bson:"imdb"`
+	} `bson:"scores"`
+}
+
+func typedScoresUp(ctx context.Context, tweets *mongo.Collection) error {
+	var legacy bson.A
+	projection := bson.M{}
+	for _, field := range legacyScoreFields {
+		legacy = append(legacy, bson.M{field: bson.M{"$exists": true}})
+		projection[field] = 1
+	}
+	projection["scores.vader"] = 1
+	projection["scores.imdb"] = 1
+	_, err := rewriteInBatches(ctx, tweets, bson.M{"$or": legacy}, projection, migrationBatchSize, func(raw bson.Raw) (bson.M, error) {
+		var doc legacyScoresDoc
+		if err := bson.Unmarshal(raw, &doc); err != nil {
+			return nil, err
+		}
+		return typedScoresUpdate(doc), nil
+	})
+	return err
+}
+
+// typedScoresUpdate converts the legacy scores of doc, scores written by the current pipeline win over legacy ones
+func typedScoresUpdate(doc legacyScoresDoc) bson.M {
+	set := bson.M{}
+	lexicon := doc.Scores.Lexicon
+	if lexicon == nil {
+		lexicon = doc.Lexicon
+	}
+	if lexicon != nil && doc.Scores.Vader == nil {
+		set["scores.vader"] = vaderFromLegacy(lexicon)
+	}
+	class := doc.Scores.IMDB
+	if class == nil {
+		class = doc.IMDB
+	}
+	if class != nil && doc.Scores.Imdb == nil {
+		set["scores.imdb"] = imdbFromLegacy(*class)
+	}
+	unset := bson.M{}
+	for _, field := range legacyScoreFields {
+		unset[field] = ""
+	}
+	update := bson.M{"$unset": unset}
+	if len(set) > 0 {
+		update["$set"] = set
+	}
+	return update
+}
+
+func vaderFromLegacy(scores map[string]float64) analysis.SentimentResult {
+	vader := analysis.VaderScorer{}
+	compound := scores["Compound"]
+	confidence := compound
+	if confidence < 0 {
+		confidence = -confidence
+	}
+	return analysis.SentimentResult{
+		Scorer:     vader.Name(),
+		Version:    vader.Version(),
+		Positive:   scores["Positive"],
+		Negative:   scores["Negative"],
+		Neutral:    scores["Neutral"],
+		Compound:   compound,
+		Label:      analysis.LabelForCompound(compound),
+		Confidence: confidence,
+	}
+}
+
+func imdbFromLegacy(class int) analysis.SentimentResult {
+	imdb := &analysis.IMDBScorer{}
+	// only the predicted class was kept, like the scorer's fallback when the probability underflows
+	result := analysis.SentimentResult{Scorer: imdb.Name(), Version: imdb.Version(), Label: analysis.LabelNegative, Negative: 1, Compound: -1}
+	if class > 0 {
+		result.Label, result.Positive, result.Negative, result.Compound = analysis.LabelPositive, 1, 0, 1
+	}
+	return result
+}
+
+// typedScoresDown restores the original top-level lexicon/imdb_ml_model fields
+func typedScoresDown(ctx context.Context, tweets *mongo.Collection) error {
+	filter := bson.M{"$or": bson.A{bson.M{"scores.vader": bson.M{"$exists": true}}, bson.M{"scores.imdb": bson.M{"$exists": true}}}}
+	projection := bson.M{"scores.vader": 1, "scores.imdb": 1}
+	_, err := rewriteInBatches(ctx, tweets, filter, projection, migrationBatchSize, func(raw bson.Raw) (bson.M, error) {
+		var doc struct {
+			Scores map[string]analysis.SentimentResult `bson:"scores"`
+		}
+		if err := bson.Unmarshal(raw, &doc); err != nil {
+			return nil, err
+		}
+		set := bson.M{}
+		if vader, ok := doc.Scores["vader"]; ok {
+			set["lexicon"] = bson.M{"Positive": vader.Positive, "Negative": vader.Negative, "Neutral": vader.Neutral, "Compound": vader.Compound}
+		}
+		if imdb, ok := doc.Scores["imdb"]; ok {
+			class := 0
+			if imdb.Label == analysis.LabelPositive {
+				class = 1
+			}
+			set["imdb_ml_model"] = class
+		}
+		return bson.M{"$set": set, "$unset": bson.M{"scores.vader": "", "scores.imdb": ""}}, nil
+	})
+	return err
+}
@@ -0,0 +1,91 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jmoussa/go-sentitweet/analysis"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestMigrationsRegistered(t *testing.T) {
+	for i, m := range Migrations() {
+		if m.Version != i+1 {
+			t.Errorf("expected contiguous versions, got %d at position %d", m.Version, i)
+		}
+	}
+}
+
+func testMigrations() []Migration {
+	noop := func(context.Context, *mongo.Collection) error { return nil }
+	return []Migration{
+		{Version: 1, Name: "one", Up: noop, Down: noop},
+		{Version: 2, Name: "two", Up: noop},
+		{Version: 3, Name: "three", Up: noop, Down: noop},
+	}
+}
+
+func versions(plan []Migration) []int {
+	var v []int
+	for _, m := range plan {
+		v = append(v, m.Version)
+	}
+	return v
+}
+
+func TestPlanUp(t *testing.T) {
+	statuses := migrationStatuses(testMigrations(), []migrationRecord{
+		{Version: 1, State: MigrationApplied},
+		// interrupted, it is applied again
+		{Version: 2, State: MigrationRunning},
+	})
+	if got := versions(planUp(statuses, 0)); len(got) != 2 || got[0] != 2 || got[1] != 3 {
+		t.Errorf("expected migrations 2 and 3, got %v", got)
+	}
+	if got := versions(planUp(statuses, 2)); len(got) != 1 || got[0] != 2 {
+		t.Errorf("expected migration 2 only, got %v", got)
+	}
+}
+
+func TestPlanDown(t *testing.T) {
+	statuses := migrationStatuses(testMigrations(), []migrationRecord{
+		{Version: 1, State: MigrationApplied},
+		{Version: 2, State: MigrationApplied},
+		{Version: 3, State: MigrationApplied},
+	})
+	if got, err := planDown(statuses, 2); err != nil || len(got) != 1 || got[0].Version != 3 {
+		t.Errorf("expected migration 3, got %v (%v)", versions(got), err)
+	}
+	if _, err := planDown(statuses, 0); err == nil {
+		t.Error("expected an error reverting the irreversible migration 2")
+	}
+}
+
+func TestTypedScoresUpdate(t *testing.T) {
+	class := 1
+	var doc legacyScoresDoc
+	doc.Lexicon = map[string]float64{"Positive": 0.5, "Negative": 0.1, "Neutral": 0.4, "Compound": -0.3}
+	doc.Scores.IMDB = &class
+	update := typedScoresUpdate(doc)
+
+	set, _ := update["$set"].(bson.M)
+	vader, _ := set["scores.vader"].(analysis.SentimentResult)
+	if vader.Compound != -0.3 || vader.Label != analysis.LabelNegative || vader.Confidence != 0.3 || vader.Scorer != "vader" {
+		t.Errorf("unexpected vader score %+v", vader)
+	}
+	imdb, _ := set["scores.imdb"].(analysis.SentimentResult)
+	if imdb.Label != analysis.LabelPositive || imdb.Compound != 1 {
+		t.Errorf("unexpected imdb score %+v", imdb)
+	}
+	if unset, _ := update["$unset"].(bson.M); len(unset) != len(legacyScoreFields) {
+		t.Errorf("expected every legacy field unset, got %v", unset)
+	}
+
+	// a score already written by the current pipeline is kept
+	doc.Scores.Vader = bson.Raw{}
+	set, _ = typedScoresUpdate(doc)["$set"].(bson.M)
+	if _, ok := set["scores.vader"]; ok {
+		t.Error("expected the existing vader score to be kept")
+	}
+}
@@ -0,0 +1,134 @@
+package db
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoussa/go-sentitweet/analysis"
+)
+
+/*
+Paginated tweet queries.
+Pages are ordered by the sort key (tweet date or a scorer's compound score), newest/highest first, with the tweet ID
+breaking ties. The cursor of the next page is the key and ID of the last tweet of the current one, so paging stays
+cheap however deep it goes and isn't thrown off by tweets stored in the meantime.
+*/
+
+const (
+	SortDate     = "date"
+	SortCompound = "compound"
+
+	DefaultPageSize = 100
+	MaxPageSize     = 1000
+	// scorer whose compound score SortCompound orders by when the query doesn't name one
+	defaultSortScorer = "vader"
+)
+
+// ErrInvalidQuery is wrapped by the errors about the query itself (bad cursor, unknown sort, ...)
+var ErrInvalidQuery = errors.New("invalid query")
+
+type TweetQuery struct {
+	TweetFilter
+	// Sort is SortDate (default) or SortCompound (by the compound score of the filter's Scorer).
+	// Tweets without a score of the Scorer are left out of SortCompound, undated tweets (stored before
+	// created_at existed) come last in SortDate
+	Sort string
+	// Limit is the page size, DefaultPageSize when 0 and at most MaxPageSize
+	Limit int
+	// Cursor is the NextCursor of the previous page, empty for the first page
+	Cursor string
+	// Fields restricts the top level fields of the returned messages (BaseTweet, Scores, ...), a hint
+	// backends that can project use to read less, the sort key and tweet ID are always returned
+	Fields []string
+}
+
+type TweetPage struct {
+	Tweets []analysis.TweetWithScoreMessage
+	// NextCursor fetches the following page, empty on the last page
+	NextCursor string
+}
+
+type pageCursor struct {
+	Sort     string    `json:"s"`
+	Scorer   string    `json:"sc,omitempty"`
+	Date     time.Time `json:"d,omitempty"`
+	Compound float64   `json:"c,omitempty"`
+	ID       int64     `json:"id"`
+}
+
+// normalize applies the query defaults and decodes its cursor, nil for the first page
+func (q *TweetQuery) normalize() (*pageCursor, error) {
+	if err := q.TweetFilter.validate(); err != nil {
+		return nil, err
+	}
+	if q.Sort == "" {
+		q.Sort = SortDate
+	}
+	if q.Sort != SortDate && q.Sort != SortCompound {
+		return nil, fmt.Errorf("%w: unknown sort %q (expected %s or %s)", ErrInvalidQuery, q.Sort, SortDate, SortCompound)
+	}
+	if q.Sort == SortCompound && q.Scorer == "" {
+		q.Scorer = defaultSortScorer
+	}
+	if q.Limit < 0 {
+		return nil, fmt.Errorf("%w: limit can't be negative", ErrInvalidQuery)
+	}
+	if q.Limit == 0 {
+		q.Limit = DefaultPageSize
+	}
+	if q.Limit > MaxPageSize {
+		q.Limit = MaxPageSize
+	}
+	if q.Cursor == "" {
+		return nil, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+	var cursor pageCursor
+	if err := json.Unmarshal(raw, &cursor); err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+	// a cursor only makes sense for the ordering it was taken from
+	if cursor.Sort != q.Sort || cursor.Scorer != q.Scorer {
+		return nil, fmt.Errorf("%w: the cursor was returned for another sort", ErrInvalidQuery)
+	}
+	return &cursor, nil
+}
+
+// page trims a result fetched with Limit+1 rows to the page and sets the cursor of the next one
+func (q TweetQuery) page(tweets []analysis.TweetWithScoreMessage) (TweetPage, error) {
+	if len(tweets) <= q.Limit {
+		return TweetPage{Tweets: tweets}, nil
+	}
+	tweets = tweets[:q.Limit]
+	last := tweets[len(tweets)-1]
+	cursor := pageCursor{Sort: q.Sort, Scorer: q.Scorer, ID: last.BaseTweet.ID}
+	if q.Sort == SortCompound {
+		cursor.Compound = last.Scores[q.Scorer].Compound
+	} else {
+		cursor.Date = last.CreatedAt
+	}
+	raw, err := json.Marshal(cursor)
+	if err != nil {
+		return TweetPage{}, err
+	}
+	return TweetPage{Tweets: tweets, NextCursor: base64.RawURLEncoding.EncodeToString(raw)}, nil
+}
+
+// after tells whether msg sorts after the cursor, i.e. belongs to a later page.
+// Undated tweets have the zero date, the lowest, so they sort last.
+func (c *pageCursor) after(msg analysis.TweetWithScoreMessage) bool {
+	if c == nil {
+		return true
+	}
+	if c.Sort == SortCompound {
+		compound := msg.Scores[c.Scorer].Compound
+		return compound < c.Compound || (compound == c.Compound && msg.BaseTweet.ID < c.ID)
+	}
+	return msg.CreatedAt.Before(c.Date) || (msg.CreatedAt.Equal(c.Date) && msg.BaseTweet.ID < c.ID)
+}
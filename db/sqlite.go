@@ -0,0 +1,376 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/dghubble/go-twitter/twitter"
+	"github.com/jmoussa/go-sentitweet/analysis"
+	"github.com/jmoussa/go-sentitweet/config"
+	_ "modernc.org/sqlite"
+)
+
+/*
+SQLite TweetStore for single-machine deployments (storage_backend: sqlite, sqlite_path: ./sentitweet.db).
+Tweets and scores are stored as JSON next to a few extracted columns, and an FTS5 index on the text backs TextSearch.
+*/
+
+const defaultSQLitePath = "sentitweet.db"
+
+var sqliteSchema = []string{
+	`CREATE TABLE IF NOT EXISTS tweets (
+		id          INTEGER PRIMARY KEY,
+		created_at  INTEGER,
+		ingested_at INTEGER,
+		term        TEXT,
+		text        TEXT NOT NULL,
+		tweet       TEXT NOT NULL,
+		scores      TEXT NOT NULL DEFAULT '{}'
+	)`,
+	`CREATE INDEX IF NOT EXISTS tweets_created_at ON tweets(created_at)`,
+	`CREATE VIRTUAL TABLE IF NOT EXISTS tweets_fts USING fts5(text, content='tweets', content_rowid='id')`,
+	// keep the external content FTS index in sync with the tweets table
+	`CREATE TRIGGER IF NOT EXISTS tweets_fts_insert AFTER INSERT ON tweets BEGIN
+		INSERT INTO tweets_fts(rowid, text) VALUES (new.id, new.text);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS tweets_fts_delete AFTER DELETE ON tweets BEGIN
+		INSERT INTO tweets_fts(tweets_fts, rowid, text) VALUES ('delete', old.id, old.text);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS tweets_fts_update AFTER UPDATE OF text ON tweets BEGIN
+		INSERT INTO tweets_fts(tweets_fts, rowid, text) VALUES ('delete', old.id, old.text);
+		INSERT INTO tweets_fts(rowid, text) VALUES (new.id, new.text);
+	END`,
+}
+
+// columns added after the first release, ALTER TABLE'd into existing databases
+var sqliteAddedColumns = []string{
+	`ingested_at INTEGER`,
+	`term TEXT`,
+}
+
+var sqliteIndexes = []string{
+	`CREATE INDEX IF NOT EXISTS tweets_term_created_at ON tweets(term, created_at)`,
+}
+
+// scorer names end up in JSON paths, keep them to simple identifiers
+var scorerNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+func NewSQLiteStore(ctx context.Context, cfg config.Config) (*SQLiteStore, error) {
+	path := cfg.General["sqlite_path"]
+	if path == "" {
+		path = defaultSQLitePath
+	}
+	// WAL lets the API read while the pipeline writes, busy_timeout waits out the remaining lock contention
+	dsn := "file:" + path + "?" + url.Values{"_pragma": {"busy_timeout(5000)", "journal_mode(WAL)"}}.Encode()
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("could not open sqlite database %s: %w", path, err)
+	}
+	for _, statement := range sqliteSchema {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("could not create sqlite schema: %w", err)
+		}
+	}
+	existing, err := sqliteColumns(ctx, db, "tweets")
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	for _, column := range sqliteAddedColumns {
+		name, _, _ := strings.Cut(column, " ")
+		if existing[name] {
+			continue
+		}
+		if _, err := db.ExecContext(ctx, `ALTER TABLE tweets ADD COLUMN `+column); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("could not add %s to the sqlite schema: %w", column, err)
+		}
+	}
+	for _, statement := range sqliteIndexes {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("could not create sqlite index: %w", err)
+		}
+	}
+	return &SQLiteStore{db: db}, nil
+}
+
+// sqliteColumns lists the columns of a table
+func sqliteColumns(ctx context.Context, db *sql.DB, table string) (map[string]bool, error) {
+	rows, err := db.QueryContext(ctx, `SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return nil, fmt.Errorf("could not read the columns of %s: %w", table, err)
+	}
+	defer rows.Close()
+	columns := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("could not read the columns of %s: %w", table, err)
+		}
+		columns[name] = true
+	}
+	return columns, rows.Err()
+}
+
+func (s *SQLiteStore) UpsertMany(ctx context.Context, msgs []analysis.TweetWithScoreMessage) (errs []error) {
+	defer observeBatch(ctx, "sqlite", "upsert_many")(&errs)
+	errs = make([]error, len(msgs))
+	fail := func(err error) []error {
+		for i := range errs {
+			if errs[i] == nil {
+				errs[i] = err
+			}
+		}
+		return errs
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fail(err)
+	}
+	defer tx.Rollback()
+	// json_patch merges the new scores into the stored ones, like the $set of each score in mongo
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO tweets (id, created_at, ingested_at, term, text, tweet, scores) VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET
+			created_at = excluded.created_at,
+			term = COALESCE(excluded.term, tweets.term),
+			text = excluded.text,
+			tweet = excluded.tweet,
+			scores = json_patch(tweets.scores, excluded.scores)`)
+	if err != nil {
+		return fail(err)
+	}
+	defer stmt.Close()
+
+	now := time.Now().Unix()
+	for i, msg := range msgs {
+		if msg.BaseTweet == nil {
+			errs[i] = fmt.Errorf("can't store a message without a tweet")
+			continue
+		}
+		tweet, err := json.Marshal(msg.BaseTweet)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		scores := []byte("{}")
+		if len(msg.Scores) > 0 {
+			if scores, err = json.Marshal(msg.Scores); err != nil {
+				errs[i] = err
+				continue
+			}
+		}
+		var createdAt interface{}
+		if t, err := msg.BaseTweet.CreatedAtTime(); err == nil {
+			createdAt = t.Unix()
+		}
+		var term interface{}
+		if msg.Term != "" {
+			term = msg.Term
+		}
+		if _, err := stmt.ExecContext(ctx, msg.BaseTweet.ID, createdAt, now, term, msg.BaseTweet.Text, string(tweet), string(scores)); err != nil {
+			errs[i] = err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fail(err)
+	}
+	return errs
+}
+
+func (s *SQLiteStore) Get(ctx context.Context, id int64) (_ analysis.TweetWithScoreMessage, err error) {
+	defer observe(ctx, "sqlite", "get")(&err)
+	tweets, err := s.query(ctx, `SELECT tweet, scores, created_at, ingested_at, term FROM tweets WHERE id = ?`, id)
+	if err != nil {
+		return analysis.TweetWithScoreMessage{}, err
+	}
+	if len(tweets) == 0 {
+		return analysis.TweetWithScoreMessage{}, ErrNotFound
+	}
+	return tweets[0], nil
+}
+
+func (s *SQLiteStore) TextSearch(ctx context.Context, searchPhrase string) (_ []analysis.TweetWithScoreMessage, err error) {
+	defer observe(ctx, "sqlite", "text_search")(&err)
+	if strings.TrimSpace(searchPhrase) == "" {
+		return s.query(ctx, `SELECT tweet, scores, created_at, ingested_at, term FROM tweets ORDER BY id`)
+	}
+	// search the phrase as a whole rather than exposing the FTS5 query syntax
+	return s.query(ctx, `SELECT t.tweet, t.scores, t.created_at, t.ingested_at, t.term FROM tweets_fts
+		JOIN tweets t ON t.id = tweets_fts.rowid
+		WHERE tweets_fts MATCH ?
+		ORDER BY bm25(tweets_fts)`, ftsPhrase(searchPhrase))
+}
+
+func (s *SQLiteStore) FindRecent(ctx context.Context, daysBack int) (_ []analysis.TweetWithScoreMessage, err error) {
+	defer observe(ctx, "sqlite", "find_recent")(&err)
+	cutoff := time.Now().AddDate(0, 0, -daysBack).Unix()
+	return s.query(ctx, `SELECT tweet, scores, created_at, ingested_at, term FROM tweets WHERE created_at >= ? ORDER BY created_at DESC`, cutoff)
+}
+
+func (s *SQLiteStore) Find(ctx context.Context, q TweetQuery) (_ TweetPage, err error) {
+	defer observe(ctx, "sqlite", "find")(&err)
+	cursor, err := q.normalize()
+	if err != nil {
+		return TweetPage{}, err
+	}
+	query := `SELECT t.tweet, t.scores, t.created_at, t.ingested_at, t.term FROM tweets t`
+	var (
+		where []string
+		args  []interface{}
+	)
+	if strings.TrimSpace(q.SearchPhrase) != "" {
+		query += ` JOIN tweets_fts ON tweets_fts.rowid = t.id`
+		where = append(where, `tweets_fts MATCH ?`)
+		args = append(args, ftsPhrase(q.SearchPhrase))
+	}
+	filterWhere, filterArgs := q.sqlFilter()
+	where = append(where, filterWhere...)
+	args = append(args, filterArgs...)
+	// q.Scorer is validated by normalize, safe to use in the JSON path
+	// undated tweets (NULL, the lowest) come last when sorting by date
+	key := `t.created_at`
+	if q.Sort == SortCompound {
+		key = `json_extract(t.scores, '$.` + q.Scorer + `.compound')`
+		where = append(where, key+` IS NOT NULL`)
+	}
+	switch {
+	case cursor == nil:
+	case q.Sort == SortCompound:
+		where = append(where, `(`+key+` < ? OR (`+key+` = ? AND t.id < ?))`)
+		args = append(args, cursor.Compound, cursor.Compound, cursor.ID)
+	case cursor.Date.IsZero():
+		where = append(where, `(`+key+` IS NULL AND t.id < ?)`)
+		args = append(args, cursor.ID)
+	default:
+		where = append(where, `(`+key+` < ? OR (`+key+` = ? AND t.id < ?) OR `+key+` IS NULL)`)
+		args = append(args, cursor.Date.Unix(), cursor.Date.Unix(), cursor.ID)
+	}
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, ` AND `)
+	}
+	query += ` ORDER BY ` + key + ` DESC, t.id DESC LIMIT ?`
+	args = append(args, q.Limit+1)
+	tweets, err := s.query(ctx, query, args...)
+	if err != nil {
+		return TweetPage{}, err
+	}
+	return q.page(tweets)
+}
+
+func (s *SQLiteStore) TimeSeries(ctx context.Context, q TimeSeriesQuery) (_ []SentimentBucket, err error) {
+	defer observe(ctx, "sqlite", "time_series")(&err)
+	if err := q.normalize(); err != nil {
+		return nil, err
+	}
+	// q.Scorer is validated by normalize, safe to use in the JSON path
+	score := `'$.` + q.Scorer
+	query := `SELECT t.created_at, json_extract(t.scores, ` + score + `.compound'), json_extract(t.scores, ` + score + `.label') FROM tweets t`
+	where, args := q.sqlFilter()
+	if strings.TrimSpace(q.SearchPhrase) != "" {
+		query += ` JOIN tweets_fts ON tweets_fts.rowid = t.id`
+		where = append(where, `tweets_fts MATCH ?`)
+		args = append(args, ftsPhrase(q.SearchPhrase))
+	}
+	where = append(where, `t.created_at IS NOT NULL`, `json_extract(t.scores, `+score+`.compound') IS NOT NULL`)
+	rows, err := s.db.QueryContext(ctx, query+` WHERE `+strings.Join(where, ` AND `), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query the time series: %w", err)
+	}
+	defer rows.Close()
+	buckets := newBucketizer(q.Bucket)
+	for rows.Next() {
+		var (
+			createdAt int64
+			point     scoredPoint
+			label     sql.NullString
+		)
+		if err := rows.Scan(&createdAt, &point.Compound, &label); err != nil {
+			return nil, err
+		}
+		point.CreatedAt, point.Label = time.Unix(createdAt, 0), label.String
+		buckets.add(point)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return buckets.result(), nil
+}
+
+func (s *SQLiteStore) Aggregate(ctx context.Context, scorer string) (_ ScoreSummary, err error) {
+	defer observe(ctx, "sqlite", "aggregate")(&err)
+	summary := ScoreSummary{Scorer: scorer}
+	if !scorerNamePattern.MatchString(scorer) {
+		return summary, fmt.Errorf("invalid scorer name %q", scorer)
+	}
+	path := "$." + scorer
+	var mean sql.NullFloat64
+	err = s.db.QueryRowContext(ctx, `SELECT
+			COUNT(*),
+			AVG(json_extract(scores, ? || '.compound')),
+			COALESCE(SUM(json_extract(scores, ? || '.label') = ?), 0),
+			COALESCE(SUM(json_extract(scores, ? || '.label') = ?), 0),
+			COALESCE(SUM(json_extract(scores, ? || '.label') = ?), 0)
+		FROM tweets WHERE json_type(scores, ?) IS NOT NULL`,
+		path, path, analysis.LabelPositive, path, analysis.LabelNegative, path, analysis.LabelNeutral, path,
+	).Scan(&summary.Count, &mean, &summary.Positive, &summary.Negative, &summary.Neutral)
+	if err != nil {
+		return summary, fmt.Errorf("failed to aggregate scores: %w", err)
+	}
+	summary.MeanCompound = mean.Float64
+	return summary, nil
+}
+
+func (s *SQLiteStore) Close(ctx context.Context) error {
+	return s.db.Close()
+}
+
+func (s *SQLiteStore) query(ctx context.Context, query string, args ...interface{}) ([]analysis.TweetWithScoreMessage, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply DB Query: %w", err)
+	}
+	defer rows.Close()
+	var tweets []analysis.TweetWithScoreMessage
+	for rows.Next() {
+		var (
+			rawTweet, rawScores   string
+			createdAt, ingestedAt sql.NullInt64
+			term                  sql.NullString
+		)
+		if err := rows.Scan(&rawTweet, &rawScores, &createdAt, &ingestedAt, &term); err != nil {
+			return nil, err
+		}
+		msg := analysis.TweetWithScoreMessage{Term: term.String}
+		if createdAt.Valid {
+			msg.CreatedAt = time.Unix(createdAt.Int64, 0).UTC()
+		}
+		if ingestedAt.Valid {
+			msg.IngestedAt = time.Unix(ingestedAt.Int64, 0).UTC()
+		}
+		msg.BaseTweet = &twitter.Tweet{}
+		if err := json.Unmarshal([]byte(rawTweet), msg.BaseTweet); err != nil {
+			return nil, fmt.Errorf("corrupt tweet in sqlite: %w", err)
+		}
+		if err := json.Unmarshal([]byte(rawScores), &msg.Scores); err != nil {
+			return nil, fmt.Errorf("corrupt scores in sqlite: %w", err)
+		}
+		tweets = append(tweets, msg)
+	}
+	return tweets, rows.Err()
+}
+
+func ftsPhrase(searchPhrase string) string {
+	return `"` + strings.ReplaceAll(searchPhrase, `"`, `""`) + `"`
+}
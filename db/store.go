@@ -0,0 +1,58 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jmoussa/go-sentitweet/analysis"
+	"github.com/jmoussa/go-sentitweet/config"
+)
+
+/*
+Storage layer used by the pipeline and the API.
+The backend is picked with "storage_backend" in config.json: mongo (default), sqlite or memory.
+*/
+
+var ErrNotFound = errors.New("tweet not found")
+
+type TweetStore interface {
+	// UpsertMany writes scored tweets (merging scores into existing documents),
+	// returning one error per tweet, nil for the ones that were written
+	UpsertMany(ctx context.Context, msgs []analysis.TweetWithScoreMessage) []error
+	// Get returns ErrNotFound when the tweet isn't stored
+	Get(ctx context.Context, id int64) (analysis.TweetWithScoreMessage, error)
+	TextSearch(ctx context.Context, searchPhrase string) ([]analysis.TweetWithScoreMessage, error)
+	// FindRecent returns the tweets created in the last daysBack days, newest first
+	FindRecent(ctx context.Context, daysBack int) ([]analysis.TweetWithScoreMessage, error)
+	// Find returns one page of the tweets matching q, see TweetQuery
+	Find(ctx context.Context, q TweetQuery) (TweetPage, error)
+	// TimeSeries buckets the tweets matching q by creation time, see TimeSeriesQuery
+	TimeSeries(ctx context.Context, q TimeSeriesQuery) ([]SentimentBucket, error)
+	// Aggregate summarizes the scores of one scorer over every stored tweet
+	Aggregate(ctx context.Context, scorer string) (ScoreSummary, error)
+	Close(ctx context.Context) error
+}
+
+type ScoreSummary struct {
+	Scorer       string  `json:"scorer" bson:"-"`
+	Count        int64   `json:"count" bson:"count"`
+	MeanCompound float64 `json:"mean_compound" bson:"mean_compound"`
+	Positive     int64   `json:"positive" bson:"positive"`
+	Negative     int64   `json:"negative" bson:"negative"`
+	Neutral      int64   `json:"neutral" bson:"neutral"`
+}
+
+// OpenStore opens the storage backend selected by cfg
+func OpenStore(ctx context.Context, cfg config.Config) (TweetStore, error) {
+	switch backend := cfg.General["storage_backend"]; backend {
+	case "", "mongo":
+		return NewMongoStore(ctx, cfg)
+	case "sqlite":
+		return NewSQLiteStore(ctx, cfg)
+	case "memory":
+		return NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage_backend %q (expected mongo, sqlite or memory)", backend)
+	}
+}
@@ -0,0 +1,219 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dghubble/go-twitter/twitter"
+	"github.com/jmoussa/go-sentitweet/analysis"
+	"github.com/jmoussa/go-sentitweet/config"
+)
+
+func scoredTweet(id int64, text string, createdAt time.Time, scorer string, compound float64) analysis.TweetWithScoreMessage {
+	return analysis.TweetWithScoreMessage{
+		BaseTweet: &twitter.Tweet{ID: id, Text: text, CreatedAt: createdAt.Format(time.RubyDate)},
+		Scores: map[string]analysis.SentimentResult{
+			scorer: {Scorer: scorer, Compound: compound, Label: analysis.LabelForCompound(compound)},
+		},
+	}
+}
+
+// testTweetStore checks the behaviour every TweetStore implementation must share
+func testTweetStore(t *testing.T, store TweetStore) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+	msgs := []analysis.TweetWithScoreMessage{
+		scoredTweet(1, "I love #golang", now, "vader", 0.6),
+		scoredTweet(2, "golang generics are confusing", now.AddDate(0, 0, -2), "vader", -0.4),
+		scoredTweet(3, "rainy monday", now.AddDate(0, 0, -10), "vader", 0),
+		// a second scorer for tweet 1 is merged with the first
+		scoredTweet(1, "I love #golang", now, "imdb", 1),
+	}
+	msgs[0].Term = "#golang"
+	for i, err := range store.UpsertMany(ctx, msgs) {
+		if err != nil {
+			t.Fatalf("UpsertMany[%d]: %v", i, err)
+		}
+	}
+
+	tweet, err := store.Get(ctx, 1)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if tweet.BaseTweet.Text != "I love #golang" || len(tweet.Scores) != 2 || tweet.Scores["vader"].Compound != 0.6 {
+		t.Errorf("expected merged scores for tweet 1, got %+v", tweet)
+	}
+	if tweet.Term != "#golang" {
+		t.Errorf("expected the term to be kept across upserts, got %q", tweet.Term)
+	}
+	if !tweet.CreatedAt.Equal(now) || tweet.IngestedAt.IsZero() {
+		t.Errorf("expected native created_at %v and an ingested_at, got %v and %v", now, tweet.CreatedAt, tweet.IngestedAt)
+	}
+	if _, err := store.Get(ctx, 99); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	found, err := store.TextSearch(ctx, "golang")
+	if err != nil || len(found) != 2 {
+		t.Errorf("TextSearch: expected 2 tweets, got %d (%v)", len(found), err)
+	}
+	// a phrase, case insensitive, regex characters included
+	found, err = store.TextSearch(ctx, "I LOVE")
+	if err != nil || len(found) != 1 {
+		t.Errorf("TextSearch: expected the phrase to match tweet 1 whatever the case, got %d (%v)", len(found), err)
+	}
+	found, err = store.TextSearch(ctx, "C++ (")
+	if err != nil || len(found) != 0 {
+		t.Errorf("TextSearch: expected no tweet and no error for regex characters, got %d (%v)", len(found), err)
+	}
+
+	recent, err := store.FindRecent(ctx, 5)
+	if err != nil || len(recent) != 2 || recent[0].BaseTweet.ID != 1 {
+		t.Errorf("FindRecent: expected tweets 1 and 2 newest first, got %d (%v)", len(recent), err)
+	}
+
+	summary, err := store.Aggregate(ctx, "vader")
+	if err != nil {
+		t.Fatalf("Aggregate: %v", err)
+	}
+	if summary.Count != 3 || summary.Positive != 1 || summary.Negative != 1 || summary.Neutral != 1 {
+		t.Errorf("unexpected summary %+v", summary)
+	}
+	if mean := summary.MeanCompound; mean < 0.066 || mean > 0.067 {
+		t.Errorf("unexpected mean compound %v", mean)
+	}
+
+	testFindPages(t, store)
+}
+
+// testFindPages pages through the tweets stored by testTweetStore
+func testFindPages(t *testing.T, store TweetStore) {
+	ctx := context.Background()
+	var ids []int64
+	q := TweetQuery{Limit: 2}
+	for i := 0; i < 3; i++ {
+		page, err := store.Find(ctx, q)
+		if err != nil {
+			t.Fatalf("Find: %v", err)
+		}
+		for _, tweet := range page.Tweets {
+			ids = append(ids, tweet.BaseTweet.ID)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		q.Cursor = page.NextCursor
+	}
+	if len(ids) != 3 || ids[0] != 1 || ids[1] != 2 || ids[2] != 3 {
+		t.Errorf("expected tweets 1, 2, 3 newest first over two pages, got %v", ids)
+	}
+
+	page, err := store.Find(ctx, TweetQuery{TweetFilter: TweetFilter{DaysBack: 5}, Sort: SortCompound, Limit: 1})
+	if err != nil || len(page.Tweets) != 1 || page.Tweets[0].BaseTweet.ID != 1 || page.NextCursor == "" {
+		t.Fatalf("Find by compound: unexpected first page %+v (%v)", page, err)
+	}
+	page, err = store.Find(ctx, TweetQuery{TweetFilter: TweetFilter{DaysBack: 5}, Sort: SortCompound, Limit: 1, Cursor: page.NextCursor})
+	if err != nil || len(page.Tweets) != 1 || page.Tweets[0].BaseTweet.ID != 2 || page.NextCursor != "" {
+		t.Errorf("Find by compound: unexpected last page %+v (%v)", page, err)
+	}
+
+	page, err = store.Find(ctx, TweetQuery{TweetFilter: TweetFilter{SearchPhrase: "golang"}})
+	if err != nil || len(page.Tweets) != 2 {
+		t.Errorf("Find with a search phrase: expected 2 tweets, got %d (%v)", len(page.Tweets), err)
+	}
+
+	if _, err := store.Find(ctx, TweetQuery{Cursor: "not a cursor"}); !errors.Is(err, ErrInvalidQuery) {
+		t.Errorf("expected ErrInvalidQuery for a bad cursor, got %v", err)
+	}
+}
+
+// testFindUndated pages by date through tweets stored without a date, which come last
+func testFindUndated(t *testing.T, store TweetStore) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+	msgs := []analysis.TweetWithScoreMessage{
+		scoredTweet(1, "dated", now, "vader", 0.1),
+		scoredTweet(2, "undated", now, "vader", 0.2),
+		scoredTweet(3, "undated too", now, "vader", 0.3),
+		scoredTweet(4, "dated too", now.AddDate(0, 0, -1), "vader", 0.4),
+	}
+	msgs[1].BaseTweet.CreatedAt = ""
+	msgs[2].BaseTweet.CreatedAt = ""
+	for i, err := range store.UpsertMany(ctx, msgs) {
+		if err != nil {
+			t.Fatalf("UpsertMany[%d]: %v", i, err)
+		}
+	}
+	var ids []int64
+	q := TweetQuery{Limit: 1}
+	for i := 0; i < 5; i++ {
+		page, err := store.Find(ctx, q)
+		if err != nil {
+			t.Fatalf("Find: %v", err)
+		}
+		for _, tweet := range page.Tweets {
+			ids = append(ids, tweet.BaseTweet.ID)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		q.Cursor = page.NextCursor
+	}
+	if len(ids) != 4 || ids[0] != 1 || ids[1] != 4 || ids[2] != 3 || ids[3] != 2 {
+		t.Errorf("expected the dated tweets 1, 4 then the undated 3, 2, got %v", ids)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testTweetStore(t, NewMemoryStore())
+	testFindUndated(t, NewMemoryStore())
+}
+
+func TestSQLiteStore(t *testing.T) {
+	testTweetStore(t, newTestSQLiteStore(t))
+	testFindUndated(t, newTestSQLiteStore(t))
+}
+
+func TestSQLiteStoreAddsMissingColumns(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tweets.db")
+	cfg := config.Config{General: map[string]string{"sqlite_path": path}}
+	// a database created before the term and ingested_at columns existed
+	old, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := old.Exec(`CREATE TABLE tweets (id INTEGER PRIMARY KEY, created_at INTEGER, text TEXT NOT NULL, tweet TEXT NOT NULL, scores TEXT NOT NULL DEFAULT '{}')`); err != nil {
+		t.Fatal(err)
+	}
+	old.Close()
+
+	// opened twice, the second time every column is already there
+	for i := 0; i < 2; i++ {
+		store, err := NewSQLiteStore(context.Background(), cfg)
+		if err != nil {
+			t.Fatalf("open %d: %v", i+1, err)
+		}
+		columns, err := sqliteColumns(context.Background(), store.db, "tweets")
+		store.Close(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !columns["term"] || !columns["ingested_at"] {
+			t.Fatalf("expected the added columns, got %v", columns)
+		}
+	}
+}
+
+func newTestSQLiteStore(t *testing.T) *SQLiteStore {
+	cfg := config.Config{General: map[string]string{"sqlite_path": filepath.Join(t.TempDir(), "tweets.db")}}
+	store, err := NewSQLiteStore(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close(context.Background()) })
+	return store
+}
@@ -0,0 +1,204 @@
+package db
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/jmoussa/go-sentitweet/analysis"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+/*
+Sentiment time series: the tweets matching a filter grouped into fixed size buckets of creation time,
+with count, compound score statistics and label proportions per bucket.
+Buckets are aligned on multiples of the bucket size since the Unix epoch (UTC), only buckets with tweets are returned.
+A query spans DefaultTimeSeriesWindow unless it sets from (or daysBack), and at most MaxBuckets buckets.
+Percentiles never hold a whole bucket in memory: MongoDB approximates them with $percentile (MongoDB 7.0+),
+the other backends interpolate linearly between the closest ranks of up to percentileSample scores per bucket.
+*/
+
+const (
+	DefaultBucket = time.Hour
+	// DefaultTimeSeriesWindow is how far back from to (or now) a query without from or daysBack starts
+	DefaultTimeSeriesWindow = 7 * 24 * time.Hour
+	// MaxBuckets bounds the buckets a query spans, (to - from) / bucket
+	MaxBuckets = 1000
+	// percentileSample is the number of compound scores per bucket kept for the percentiles,
+	// picked uniformly at random (reservoir sampling) from the bigger buckets
+	percentileSample = 1000
+)
+
+type TimeSeriesQuery struct {
+	// the filter's Scorer (default vader) provides the compound scores and labels
+	TweetFilter
+	// Bucket is the width of each bucket, DefaultBucket when 0
+	Bucket time.Duration
+}
+
+type SentimentBucket struct {
+	Start          time.Time `json:"start" bson:"_id"`
+	Count          int64     `json:"count" bson:"count"`
+	MeanCompound   float64   `json:"mean_compound" bson:"mean_compound"`
+	MedianCompound float64   `json:"median_compound" bson:"median_compound"`
+	P10Compound    float64   `json:"p10_compound" bson:"p10_compound"`
+	P90Compound    float64   `json:"p90_compound" bson:"p90_compound"`
+	// proportions of the bucket's tweets with each label
+	Positive float64 `json:"positive" bson:"positive"`
+	Negative float64 `json:"negative" bson:"negative"`
+	Neutral  float64 `json:"neutral" bson:"neutral"`
+}
+
+// normalize applies the defaults, bounds the time range and rejects the queries spanning too many buckets
+func (q *TimeSeriesQuery) normalize() error {
+	if q.Bucket == 0 {
+		q.Bucket = DefaultBucket
+	}
+	if q.Bucket < time.Second || q.Bucket%time.Second != 0 {
+		return fmt.Errorf("%w: bucket must be a whole number of seconds, got %s", ErrInvalidQuery, q.Bucket)
+	}
+	if q.Scorer == "" {
+		q.Scorer = defaultSortScorer
+	}
+	if err := q.TweetFilter.validate(); err != nil {
+		return err
+	}
+	end := q.To
+	if end.IsZero() {
+		end = time.Now()
+	}
+	if q.since().IsZero() {
+		q.From = end.Add(-DefaultTimeSeriesWindow)
+	}
+	if span := end.Sub(q.since()); span > 0 && (span+q.Bucket-1)/q.Bucket > MaxBuckets {
+		return fmt.Errorf("%w: %s buckets from %s spans more than %d buckets, narrow from/to or widen the bucket",
+			ErrInvalidQuery, q.Bucket, q.since().UTC().Format(time.RFC3339), MaxBuckets)
+	}
+	return nil
+}
+
+// bucketStart is the start of the bucket t falls in
+func bucketStart(t time.Time, bucket time.Duration) time.Time {
+	return time.Unix(0, t.UnixNano()-t.UnixNano()%int64(bucket)).UTC()
+}
+
+// percentile of sorted values, p in [0, 1]
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := p * float64(len(sorted)-1)
+	lo, hi := int(math.Floor(rank)), int(math.Ceil(rank))
+	return sorted[lo] + (sorted[hi]-sorted[lo])*(rank-float64(lo))
+}
+
+// scoredPoint is one tweet's contribution to the time series
+type scoredPoint struct {
+	CreatedAt time.Time
+	Compound  float64
+	Label     string
+}
+
+// bucketAccumulator sums up the points of one bucket, keeping a bounded sample of their compound scores
+type bucketAccumulator struct {
+	bucket SentimentBucket
+	total  float64
+	sample []float64
+}
+
+// bucketizer computes the time series in Go, one point at a time, for the backends that can't aggregate themselves
+type bucketizer struct {
+	bucket  time.Duration
+	buckets map[time.Time]*bucketAccumulator
+	// seeded so the sampled percentiles of a query are reproducible
+	rand *rand.Rand
+}
+
+func newBucketizer(bucket time.Duration) *bucketizer {
+	return &bucketizer{bucket: bucket, buckets: map[time.Time]*bucketAccumulator{}, rand: rand.New(rand.NewSource(1))}
+}
+
+func (b *bucketizer) add(point scoredPoint) {
+	start := bucketStart(point.CreatedAt, b.bucket)
+	acc, ok := b.buckets[start]
+	if !ok {
+		acc = &bucketAccumulator{bucket: SentimentBucket{Start: start}}
+		b.buckets[start] = acc
+	}
+	acc.bucket.Count++
+	acc.total += point.Compound
+	switch point.Label {
+	case analysis.LabelPositive:
+		acc.bucket.Positive++
+	case analysis.LabelNegative:
+		acc.bucket.Negative++
+	case analysis.LabelNeutral:
+		acc.bucket.Neutral++
+	}
+	if len(acc.sample) < percentileSample {
+		acc.sample = append(acc.sample, point.Compound)
+	} else if i := b.rand.Int63n(acc.bucket.Count); i < percentileSample {
+		acc.sample[i] = point.Compound
+	}
+}
+
+// result is the buckets with tweets, oldest first
+func (b *bucketizer) result() []SentimentBucket {
+	buckets := make([]SentimentBucket, 0, len(b.buckets))
+	for _, acc := range b.buckets {
+		bucket := acc.bucket
+		sort.Float64s(acc.sample)
+		n := float64(bucket.Count)
+		bucket.MeanCompound = acc.total / n
+		bucket.MedianCompound = percentile(acc.sample, 0.5)
+		bucket.P10Compound = percentile(acc.sample, 0.1)
+		bucket.P90Compound = percentile(acc.sample, 0.9)
+		bucket.Positive, bucket.Negative, bucket.Neutral = bucket.Positive/n, bucket.Negative/n, bucket.Neutral/n
+		buckets = append(buckets, bucket)
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Start.Before(buckets[j].Start) })
+	return buckets
+}
+
+// mongoTimeSeriesPipeline groups the matching tweets by bucket, $percentile keeps the memory of each group bounded
+func mongoTimeSeriesPipeline(match bson.M, q TimeSeriesQuery) mongo.Pipeline {
+	score := "$scores." + q.Scorer
+	bucketMillis := q.Bucket.Milliseconds()
+	createdAt := bson.M{"$toLong": "$created_at"}
+	labelShare := func(label string) bson.M {
+		return bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{score + ".label", label}}, 1, 0}}}
+	}
+	percentiles := func(i int) bson.M {
+		return bson.M{"$arrayElemAt": bson.A{"$percentiles", i}}
+	}
+	return mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$group", Value: bson.M{
+			"_id":           bson.M{"$toDate": bson.M{"$subtract": bson.A{createdAt, bson.M{"$mod": bson.A{createdAt, bucketMillis}}}}},
+			"count":         bson.M{"$sum": 1},
+			"mean_compound": bson.M{"$avg": score + ".compound"},
+			"percentiles": bson.M{"$percentile": bson.M{
+				"input":  score + ".compound",
+				"p":      bson.A{0.1, 0.5, 0.9},
+				"method": "approximate",
+			}},
+			"positive": labelShare(analysis.LabelPositive),
+			"negative": labelShare(analysis.LabelNegative),
+			"neutral":  labelShare(analysis.LabelNeutral),
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"count":           1,
+			"mean_compound":   1,
+			"p10_compound":    percentiles(0),
+			"median_compound": percentiles(1),
+			"p90_compound":    percentiles(2),
+			"positive":        bson.M{"$divide": bson.A{"$positive", "$count"}},
+			"negative":        bson.M{"$divide": bson.A{"$negative", "$count"}},
+			"neutral":         bson.M{"$divide": bson.A{"$neutral", "$count"}},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}},
+	}
+}
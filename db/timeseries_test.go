@@ -0,0 +1,108 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jmoussa/go-sentitweet/analysis"
+)
+
+func TestPercentile(t *testing.T) {
+	sorted := []float64{-1, 0, 0.5, 1}
+	for _, tt := range []struct{ p, want float64 }{{0, -1}, {0.5, 0.25}, {0.1, -0.7}, {1, 1}} {
+		if got := percentile(sorted, tt.p); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("percentile(%v) = %v, expected %v", tt.p, got, tt.want)
+		}
+	}
+	if got := percentile([]float64{0.3}, 0.9); got != 0.3 {
+		t.Errorf("percentile of a single value = %v", got)
+	}
+}
+
+func testTimeSeries(t *testing.T, store TweetStore) []SentimentBucket {
+	ctx := context.Background()
+	start := time.Date(2022, 5, 1, 10, 0, 0, 0, time.UTC)
+	msgs := []analysis.TweetWithScoreMessage{
+		scoredTweet(1, "a", start.Add(5*time.Minute), "vader", 0.8),
+		scoredTweet(2, "b", start.Add(20*time.Minute), "vader", -0.4),
+		scoredTweet(3, "c", start.Add(50*time.Minute), "vader", 0),
+		scoredTweet(4, "d", start.Add(70*time.Minute), "vader", 0.6),
+		// another term, left out by the filter
+		scoredTweet(5, "e", start.Add(10*time.Minute), "vader", -1),
+	}
+	for i := range msgs[:4] {
+		msgs[i].Term = "#golang"
+	}
+	for i, err := range store.UpsertMany(ctx, msgs) {
+		if err != nil {
+			t.Fatalf("UpsertMany[%d]: %v", i, err)
+		}
+	}
+
+	q := TimeSeriesQuery{Bucket: time.Hour}
+	q.Term = "#golang"
+	q.From, q.To = start, start.Add(2*time.Hour)
+	buckets, err := store.TimeSeries(ctx, q)
+	if err != nil {
+		t.Fatalf("TimeSeries: %v", err)
+	}
+	if len(buckets) != 2 || !buckets[0].Start.Equal(start) || !buckets[1].Start.Equal(start.Add(time.Hour)) {
+		t.Fatalf("expected two hourly buckets from %s, got %+v", start, buckets)
+	}
+	first := buckets[0]
+	if first.Count != 3 || math.Abs(first.MeanCompound-0.4/3) > 1e-9 || first.MedianCompound != 0 {
+		t.Errorf("unexpected first bucket %+v", first)
+	}
+	if math.Abs(first.P10Compound-(-0.32)) > 1e-9 || math.Abs(first.P90Compound-0.64) > 1e-9 {
+		t.Errorf("unexpected percentiles in %+v", first)
+	}
+	if math.Abs(first.Positive-1.0/3) > 1e-9 || math.Abs(first.Negative-1.0/3) > 1e-9 || math.Abs(first.Neutral-1.0/3) > 1e-9 {
+		t.Errorf("unexpected label proportions in %+v", first)
+	}
+
+	if _, err := store.TimeSeries(ctx, TimeSeriesQuery{Bucket: time.Millisecond}); err == nil {
+		t.Error("expected an error for a sub-second bucket")
+	}
+	if _, err := store.TimeSeries(ctx, TimeSeriesQuery{Bucket: time.Second}); !errors.Is(err, ErrInvalidQuery) {
+		t.Errorf("expected the default window in seconds to span too many buckets, got %v", err)
+	}
+	// without from, the query starts DefaultTimeSeriesWindow before to
+	q = TimeSeriesQuery{Bucket: 24 * time.Hour}
+	q.Term = "#golang"
+	q.To = start.Add(DefaultTimeSeriesWindow + time.Hour)
+	if buckets, err := store.TimeSeries(ctx, q); err != nil || len(buckets) != 1 || buckets[0].Count != 1 {
+		t.Errorf("expected only the tweet within the default window, got %+v, %v", buckets, err)
+	}
+	return buckets
+}
+
+func TestBucketizerSamplesPercentiles(t *testing.T) {
+	start := time.Date(2022, 5, 1, 10, 0, 0, 0, time.UTC)
+	buckets := newBucketizer(time.Hour)
+	n := 10 * percentileSample
+	for i := 0; i < n; i++ {
+		buckets.add(scoredPoint{CreatedAt: start, Compound: float64(i) / float64(n), Label: analysis.LabelPositive})
+	}
+	if sample := len(buckets.buckets[start].sample); sample != percentileSample {
+		t.Fatalf("expected a sample of %d scores, got %d", percentileSample, sample)
+	}
+	result := buckets.result()
+	if len(result) != 1 || result[0].Count != int64(n) || result[0].Positive != 1 {
+		t.Fatalf("unexpected buckets %+v", result)
+	}
+	if median := result[0].MedianCompound; math.Abs(median-0.5) > 0.05 {
+		t.Errorf("expected a sampled median close to 0.5, got %v", median)
+	}
+}
+
+func TestTimeSeriesBackendsAgree(t *testing.T) {
+	memory := testTimeSeries(t, NewMemoryStore())
+	sqlite := testTimeSeries(t, newTestSQLiteStore(t))
+	if !reflect.DeepEqual(memory, sqlite) {
+		t.Errorf("memory and sqlite time series differ:\n%+v\n%+v", memory, sqlite)
+	}
+}
@@ -2,81 +2,451 @@ package db
 
 import (
 	"context"
-	"log"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/jmoussa/go-sentitweet/analysis"
 	"github.com/jmoussa/go-sentitweet/config"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+const (
+	defaultMongoURL        = "mongodb://localhost:27017"
+	defaultMongoDatabase   = "twitter-sentiment"
+	defaultMongoCollection = "tweets"
+)
+
 func OpenMongoClient(ctx context.Context) (*mongo.Client, error) {
 	// MongoDB Connection
 	var cfg config.Config = config.ParseConfig()
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(cfg.General["mongo_url_string"]))
+	return OpenMongoClientWithConfig(ctx, cfg)
+}
+
+// OpenMongoClientWithConfig connects a pooled client using the mongo_* settings of cfg
+func OpenMongoClientWithConfig(ctx context.Context, cfg config.Config) (*mongo.Client, error) {
+	opts, err := mongoClientOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
+	client, err := mongo.Connect(ctx, opts)
 	if err != nil {
 		return client, err
 	}
 	return client, nil
 }
 
+func mongoClientOptions(cfg config.Config) (*options.ClientOptions, error) {
+	url := cfg.General["mongo_url_string"]
+	if url == "" {
+		url = defaultMongoURL
+	}
+	opts := options.Client().ApplyURI(url)
+	if raw := cfg.General["mongo_max_pool_size"]; raw != "" {
+		size, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mongo_max_pool_size %q: %w", raw, err)
+		}
+		opts.SetMaxPoolSize(size)
+	}
+	return opts, nil
+}
+
+// TweetsCollection returns the tweets collection named by mongo_database/mongo_collection in cfg
+func TweetsCollection(client *mongo.Client, cfg config.Config) *mongo.Collection {
+	database := cfg.General["mongo_database"]
+	if database == "" {
+		database = defaultMongoDatabase
+	}
+	collection := cfg.General["mongo_collection"]
+	if collection == "" {
+		collection = defaultMongoCollection
+	}
+	return client.Database(database).Collection(collection)
+}
+
 func CloseMongoClient(client *mongo.Client, ctx context.Context) {
 	if err := client.Disconnect(ctx); err != nil {
-		log.Printf("Error: failed to disconnect mongo client with error: %s", err)
+		logger(ctx).Error().Err(err).Msg("Failed to disconnect the mongo client")
 		return
 		//panic(err)
 	}
 }
 
-func FetchRecentTweets(client *mongo.Client, ctx context.Context, daysBack int) ([]analysis.TweetWithScoreMessage, error, string) {
-	// Fetch tweets that have createdat after daysBack
-	collection := client.Database("twitter-sentiment").Collection("tweets")
+// MongoStore is the TweetStore backed by the tweets collection
+type MongoStore struct {
+	client     *mongo.Client
+	collection *mongo.Collection
+}
+
+func NewMongoStore(ctx context.Context, cfg config.Config) (*MongoStore, error) {
+	client, err := OpenMongoClientWithConfig(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to mongo: %w", err)
+	}
+	store := &MongoStore{client: client, collection: TweetsCollection(client, cfg)}
+	if cfg.General["mongo_ensure_indexes"] != "false" {
+		// queries still work without them, just slowly
+		if _, err := EnsureIndexes(ctx, store.collection); err != nil {
+			logger(ctx).Error().Err(err).Msg("Could not ensure the indexes")
+		}
+	}
+	return store, nil
+}
+
+// BackfillDates sets created_at (and ingested_at) on documents stored before they existed,
+// batchSize documents at a time. It only touches documents missing created_at so it can be
+// interrupted and re-run safely. Returns the number of documents updated.
+func (m *MongoStore) BackfillDates(ctx context.Context, batchSize int) (int64, error) {
+	return backfillDates(ctx, m.collection, batchSize)
+}
+
+func backfillDates(ctx context.Context, tweets *mongo.Collection, batchSize int) (int64, error) {
+	filter := bson.M{"created_at": bson.M{"$exists": false}, "basetweet.createdat": bson.M{"$type": "string"}}
+	projection := bson.M{"basetweet.createdat": 1, "ingested_at": 1}
+	return rewriteInBatches(ctx, tweets, filter, projection, batchSize, func(raw bson.Raw) (bson.M, error) {
+		var doc struct {
+			ID         interface{} `bson:"_id"`
+			IngestedAt *time.Time  `bson:"ingested_at"`
+			BaseTweet  struct {
+				CreatedAt string `bson:"createdat"`
+			} `bson:"basetweet"`
+		}
+		if err := bson.Unmarshal(raw, &doc); err != nil {
+			return nil, err
+		}
+		createdAt, err := time.Parse(time.RubyDate, doc.BaseTweet.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("unparseable date %q", doc.BaseTweet.CreatedAt)
+		}
+		set := bson.M{"created_at": createdAt.UTC()}
+		if doc.IngestedAt == nil {
+			// the ObjectID generated on upsert is the best record of when it was ingested
+			if oid, ok := doc.ID.(primitive.ObjectID); ok {
+				set["ingested_at"] = oid.Timestamp().UTC()
+			}
+		}
+		return bson.M{"$set": set}, nil
+	})
+}
+
+// rewriteInBatches applies the update returned by rewrite to every document matching filter, batchSize
+// documents at a time, until none match. rewrite must make the document stop matching filter. Documents
+// rewrite fails on are logged and skipped, so an interrupted run can simply be started again.
+func rewriteInBatches(ctx context.Context, coll *mongo.Collection, filter, projection bson.M, batchSize int, rewrite func(bson.Raw) (bson.M, error)) (int64, error) {
+	var updated int64
+	// skipped documents still match the filter, exclude them so batches make progress
+	var skipped []interface{}
+	for {
+		batchFilter := filter
+		if len(skipped) > 0 {
+			batchFilter = bson.M{"$and": bson.A{filter, bson.M{"_id": bson.M{"$nin": skipped}}}}
+		}
+		opts := options.Find().SetLimit(int64(batchSize)).SetProjection(projection)
+		cursor, err := coll.Find(ctx, batchFilter, opts)
+		if err != nil {
+			return updated, err
+		}
+		var docs []bson.Raw
+		if err := cursor.All(ctx, &docs); err != nil {
+			return updated, err
+		}
+		if len(docs) == 0 {
+			return updated, nil
+		}
+
+		var models []mongo.WriteModel
+		for _, raw := range docs {
+			id := raw.Lookup("_id")
+			update, err := rewrite(raw)
+			if err != nil {
+				logger(ctx).Warn().Stringer("_id", id).Err(err).Msg("Skipping document")
+				skipped = append(skipped, id)
+				continue
+			}
+			models = append(models, mongo.NewUpdateOneModel().SetFilter(bson.M{"_id": id}).SetUpdate(update))
+		}
+		if len(models) == 0 {
+			continue
+		}
+		result, err := coll.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+		if result != nil {
+			updated += result.ModifiedCount
+		}
+		if err != nil {
+			return updated, err
+		}
+		logger(ctx).Info().Int64("updated", updated).Str("collection", coll.Name()).Msg("Rewrote documents")
+	}
+}
+
+func (m *MongoStore) UpsertMany(ctx context.Context, msgs []analysis.TweetWithScoreMessage) (errs []error) {
+	defer observeBatch(ctx, "mongo", "upsert_many")(&errs)
+	now := time.Now().UTC()
+	models := make([]mongo.WriteModel, len(msgs))
+	for i, msg := range msgs {
+		models[i] = mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"basetweet.id": msg.BaseTweet.ID}).
+			SetUpdate(bson.M{
+				"$set":         upsertFields(msg),
+				"$setOnInsert": bson.M{"ingested_at": now},
+			}).
+			SetUpsert(true)
+	}
+	// unordered so one bad document doesn't stop the rest of the batch
+	result, err := m.collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+
+	errs = make([]error, len(msgs))
+	var bulkErr mongo.BulkWriteException
+	switch {
+	case err == nil:
+	case errors.As(err, &bulkErr) && bulkErr.WriteConcernError == nil:
+		for _, writeErr := range bulkErr.WriteErrors {
+			if writeErr.Index >= 0 && writeErr.Index < len(errs) {
+				errs[writeErr.Index] = writeErr
+			}
+		}
+	default:
+		// the whole batch failed (network, timeout, write concern, ...)
+		for i := range errs {
+			errs[i] = err
+		}
+	}
+	if result != nil {
+		logger(ctx).Debug().Int("tweets", len(msgs)).Int64("inserted", result.UpsertedCount).Int64("updated", result.ModifiedCount).Msg("Upserted batch")
+	}
+	return errs
+}
+
+func upsertFields(msg analysis.TweetWithScoreMessage) bson.M {
+	// set each score individually so separate uploads for the same tweet don't clobber each other
+	set := bson.M{"basetweet": msg.BaseTweet}
+	if msg.Term != "" {
+		set["term"] = msg.Term
+	}
+	// a native date so time range queries compare dates rather than Ruby date strings
+	if createdAt, err := msg.BaseTweet.CreatedAtTime(); err == nil {
+		set["created_at"] = createdAt.UTC()
+	}
+	for t, score := range msg.Scores {
+		set["scores."+t] = score
+	}
+	return set
+}
+
+func (m *MongoStore) Get(ctx context.Context, id int64) (_ analysis.TweetWithScoreMessage, err error) {
+	defer observe(ctx, "mongo", "get")(&err)
+	var tweet analysis.TweetWithScoreMessage
+	err = m.collection.FindOne(ctx, bson.M{"basetweet.id": id}).Decode(&tweet)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return tweet, ErrNotFound
+	}
+	return tweet, err
+}
+
+func (m *MongoStore) FindRecent(ctx context.Context, daysBack int) (_ []analysis.TweetWithScoreMessage, err error) {
+	defer observe(ctx, "mongo", "find_recent")(&err)
+	// Fetch tweets that have created_at after daysBack
 	now := time.Now()
 	date := now.AddDate(0, 0, -daysBack)
-	log.Printf("**DB Searching: %d days back after %s", daysBack, date.Format("Mon Jan 2 15:04:05 -0700 2006"))
-	searchParam := bson.M{"basetweet.createdat": bson.M{"$lte": date.Format("Mon Jan 2 15:04:05 -0700 2006")}}
+	logger(ctx).Debug().Int("days_back", daysBack).Time("after", date).Msg("Searching recent tweets")
+	searchParam := bson.M{"created_at": bson.M{"$gte": date}}
 	// Acquire Query Cursor
 	findOptions := options.Find()
 	// -1 sorts descending
-	findOptions.SetSort(bson.D{{"basetweet.createdat", -1}})
-	filterCursor, err := collection.Find(ctx, searchParam, findOptions)
+	findOptions.SetSort(bson.D{{Key: "created_at", Value: -1}})
+	return m.find(ctx, searchParam, findOptions)
+}
+
+func (m *MongoStore) TextSearch(ctx context.Context, searchPhrase string) (_ []analysis.TweetWithScoreMessage, err error) {
+	defer observe(ctx, "mongo", "text_search")(&err)
+	// MongoDB Query
+	logger(ctx).Debug().Str("search", searchPhrase).Msg("Searching tweets")
+	if len(searchPhrase) == 0 {
+		return m.find(ctx, bson.M{})
+	}
+	// the phrase as a whole through the text index, most relevant first
+	searchParam := bson.M{"$text": bson.M{"$search": textSearchPhrase(searchPhrase)}}
+	score := bson.M{"score": bson.M{"$meta": "textScore"}}
+	tweets, err := m.find(ctx, searchParam, options.Find().SetProjection(score).SetSort(score))
+	// IndexNotFound, "text index required for $text query"
+	if err == nil || !isIndexNotFound(err) {
+		return tweets, err
+	}
+	// no text index (yet), fall back to scanning with a regex
+	logger(ctx).Warn().Str("collection", m.collection.Name()).Msg("No text index, falling back to a $regex scan")
+	return m.find(ctx, bson.M{"basetweet.text": phraseRegex(searchPhrase)})
+}
+
+// textSearchPhrase quotes the search phrase so $text matches it as a phrase, like the sqlite store
+func textSearchPhrase(searchPhrase string) string {
+	return `"` + strings.ReplaceAll(searchPhrase, `"`, " ") + `"`
+}
+
+// mongoFields maps the top level message fields to their document keys
+var mongoFields = map[string]string{
+	"BaseTweet":  "basetweet",
+	"Scores":     "scores",
+	"CreatedAt":  "created_at",
+	"IngestedAt": "ingested_at",
+	"Term":       "term",
+}
+
+func (m *MongoStore) Find(ctx context.Context, q TweetQuery) (_ TweetPage, err error) {
+	defer observe(ctx, "mongo", "find")(&err)
+	cursor, err := q.normalize()
 	if err != nil {
-		log.Print(err)
-		return nil, err, "failed to apply DB Query"
+		return TweetPage{}, err
 	}
-	defer filterCursor.Close(ctx)
-	// Run search w/filter
+	// tweets not backfilled yet have no created_at, which sorts lowest, so they come last when sorting by date
+	key := "created_at"
+	filter := q.mongoFilter()
+	if q.Sort == SortCompound {
+		key = "scores." + q.Scorer + ".compound"
+		filter[key] = bson.M{"$exists": true}
+	}
+	switch {
+	case cursor == nil:
+	case q.Sort == SortCompound:
+		filter["$or"] = bson.A{
+			bson.M{key: bson.M{"$lt": cursor.Compound}},
+			bson.M{key: cursor.Compound, "basetweet.id": bson.M{"$lt": cursor.ID}},
+		}
+	case cursor.Date.IsZero():
+		// null matches the missing created_at too
+		filter[key] = nil
+		filter["basetweet.id"] = bson.M{"$lt": cursor.ID}
+	default:
+		filter["$or"] = bson.A{
+			bson.M{key: bson.M{"$lt": cursor.Date}},
+			bson.M{key: cursor.Date, "basetweet.id": bson.M{"$lt": cursor.ID}},
+			bson.M{key: nil},
+		}
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: key, Value: -1}, {Key: "basetweet.id", Value: -1}}).
+		SetLimit(int64(q.Limit + 1))
+	if len(q.Fields) > 0 {
+		// the tweet ID and sort key are needed for the next cursor
+		projection := bson.M{"basetweet.id": 1, key: 1}
+		for _, field := range q.Fields {
+			if name, ok := mongoFields[field]; ok {
+				projection[name] = 1
+			}
+		}
+		if projection["basetweet"] != nil {
+			delete(projection, "basetweet.id")
+		}
+		if projection["scores"] != nil && q.Sort == SortCompound {
+			delete(projection, key)
+		}
+		opts.SetProjection(projection)
+	}
+
 	var tweets []analysis.TweetWithScoreMessage
-	if err = filterCursor.All(ctx, &tweets); err != nil {
-		log.Print(err)
-		return nil, err, "failed to Search DB"
+	if q.SearchPhrase != "" {
+		filter["$text"] = bson.M{"$search": textSearchPhrase(q.SearchPhrase)}
+		tweets, err = m.find(ctx, filter, opts)
+		if err != nil && isIndexNotFound(err) {
+			delete(filter, "$text")
+			filter["basetweet.text"] = phraseRegex(q.SearchPhrase)
+			tweets, err = m.find(ctx, filter, opts)
+		}
+	} else {
+		tweets, err = m.find(ctx, filter, opts)
 	}
-	return tweets, nil, ""
+	if err != nil {
+		return TweetPage{}, err
+	}
+	return q.page(tweets)
+}
 
+func (m *MongoStore) TimeSeries(ctx context.Context, q TimeSeriesQuery) (_ []SentimentBucket, err error) {
+	defer observe(ctx, "mongo", "time_series")(&err)
+	if err := q.normalize(); err != nil {
+		return nil, err
+	}
+	match := q.mongoFilter()
+	match["created_at"] = bson.M{"$exists": true}
+	match["scores."+q.Scorer+".compound"] = bson.M{"$exists": true}
+	if q.SearchPhrase != "" {
+		// $text has to be in the first $match, which it is
+		match["$text"] = bson.M{"$search": textSearchPhrase(q.SearchPhrase)}
+	}
+	buckets, err := m.aggregateBuckets(ctx, mongoTimeSeriesPipeline(match, q))
+	if err != nil && q.SearchPhrase != "" && isIndexNotFound(err) {
+		delete(match, "$text")
+		match["basetweet.text"] = phraseRegex(q.SearchPhrase)
+		buckets, err = m.aggregateBuckets(ctx, mongoTimeSeriesPipeline(match, q))
+	}
+	return buckets, err
 }
 
-func TextSearchQueryMongoClient(client *mongo.Client, ctx context.Context, searchPhrase string) ([]analysis.TweetWithScoreMessage, error, string) {
-	// MongoDB Query
-	collection := client.Database("twitter-sentiment").Collection("tweets")
-	log.Printf("Searching: %s", searchPhrase)
-	searchParam := bson.M{}
-	if len(searchPhrase) > 0 {
-		searchParam = bson.M{"basetweet.text": bson.M{"$regex": searchPhrase}}
+func (m *MongoStore) aggregateBuckets(ctx context.Context, pipeline mongo.Pipeline) ([]SentimentBucket, error) {
+	// the groups of a wide match can outgrow the in-memory limit of $group
+	cursor, err := m.collection.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate the time series: %w", err)
+	}
+	buckets := []SentimentBucket{}
+	if err := cursor.All(ctx, &buckets); err != nil {
+		return nil, fmt.Errorf("failed to aggregate the time series: %w", err)
+	}
+	for i := range buckets {
+		buckets[i].Start = buckets[i].Start.UTC()
 	}
+	return buckets, nil
+}
+
+func (m *MongoStore) find(ctx context.Context, filter bson.M, opts ...*options.FindOptions) ([]analysis.TweetWithScoreMessage, error) {
 	// Acquire Query Cursor
-	filterCursor, err := collection.Find(ctx, searchParam)
+	filterCursor, err := m.collection.Find(ctx, filter, opts...)
 	if err != nil {
-		log.Print(err)
-		return nil, err, "failed to apply DB Query"
+		return nil, fmt.Errorf("failed to apply DB Query: %w", err)
 	}
 	defer filterCursor.Close(ctx)
 	// Run search w/filter
 	var tweets []analysis.TweetWithScoreMessage
 	if err = filterCursor.All(ctx, &tweets); err != nil {
-		log.Print(err)
-		return nil, err, "failed to Search DB"
+		return nil, fmt.Errorf("failed to Search DB: %w", err)
+	}
+	return tweets, nil
+}
+
+func (m *MongoStore) Aggregate(ctx context.Context, scorer string) (_ ScoreSummary, err error) {
+	defer observe(ctx, "mongo", "aggregate")(&err)
+	summary := ScoreSummary{Scorer: scorer}
+	field := "$scores." + scorer
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"scores." + scorer: bson.M{"$exists": true}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":           nil,
+			"count":         bson.M{"$sum": 1},
+			"mean_compound": bson.M{"$avg": field + ".compound"},
+			"positive":      bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{field + ".label", analysis.LabelPositive}}, 1, 0}}},
+			"negative":      bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{field + ".label", analysis.LabelNegative}}, 1, 0}}},
+			"neutral":       bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{field + ".label", analysis.LabelNeutral}}, 1, 0}}},
+		}}},
 	}
-	return tweets, nil, ""
+	cursor, err := m.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return summary, fmt.Errorf("failed to aggregate scores: %w", err)
+	}
+	defer cursor.Close(ctx)
+	if cursor.Next(ctx) {
+		if err := cursor.Decode(&summary); err != nil {
+			return summary, err
+		}
+		summary.Scorer = scorer
+	}
+	return summary, cursor.Err()
+}
+
+func (m *MongoStore) Close(ctx context.Context) error {
+	return m.client.Disconnect(ctx)
 }
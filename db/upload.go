@@ -0,0 +1,166 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jmoussa/go-sentitweet/analysis"
+	"github.com/jmoussa/go-sentitweet/config"
+)
+
+/*
+Upload stage of the pipeline.
+Holds one long-lived store (a pooled Mongo client by default) for the whole run and buffers tweets into bulk upserts,
+flushed when the batch is full (upload_batch_size) or has waited long enough (upload_flush_interval).
+Upload only queues the tweet (blocking while the queue is full), the tweets that fail to be written
+are reported on Errors and the written ones handed to the onStored callback.
+*/
+
+const (
+	uploadTimeout              = 10 * time.Second
+	defaultUploadBatchSize     = 500
+	defaultUploadFlushInterval = 2 * time.Second
+)
+
+var errUploaderClosed = errors.New("uploader is closed")
+
+type TweetUploader struct {
+	store         TweetStore
+	batchSize     int
+	flushInterval time.Duration
+	onStored      func(analysis.TweetWithScoreMessage)
+
+	mu      sync.RWMutex
+	closed  bool
+	queue   chan analysis.TweetWithScoreMessage
+	errors  chan error
+	stopped chan struct{}
+}
+
+// NewTweetUploader batches uploads into store, the uploader owns the store and closes it.
+// onStored (optional) is called with every tweet once it is written.
+func NewTweetUploader(store TweetStore, cfg config.Config, onStored func(analysis.TweetWithScoreMessage)) (*TweetUploader, error) {
+	batchSize := defaultUploadBatchSize
+	if raw := cfg.General["upload_batch_size"]; raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid upload_batch_size %q", raw)
+		}
+		batchSize = n
+	}
+	flushInterval := defaultUploadFlushInterval
+	if raw := cfg.General["upload_flush_interval"]; raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid upload_flush_interval %q", raw)
+		}
+		flushInterval = d
+	}
+
+	u := &TweetUploader{
+		store:         store,
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		onStored:      onStored,
+		queue:         make(chan analysis.TweetWithScoreMessage, batchSize),
+		errors:        make(chan error, batchSize),
+		stopped:       make(chan struct{}),
+	}
+	go u.run()
+	return u, nil
+}
+
+// Upload queues a scored tweet for the next bulk upsert without waiting for it to be written,
+// it has the pipeline step signature
+func (u *TweetUploader) Upload(s interface{}) (interface{}, error) {
+	msg, ok := s.(analysis.TweetWithScoreMessage)
+	if !ok || msg.BaseTweet == nil {
+		return nil, fmt.Errorf("format and upload: expected a scored tweet, got %T", s)
+	}
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	if u.closed {
+		return nil, errUploaderClosed
+	}
+	u.queue <- msg
+	return msg, nil
+}
+
+// Errors reports the tweets that could not be written, it is closed once the last batch is flushed
+func (u *TweetUploader) Errors() <-chan error {
+	return u.errors
+}
+
+// Close flushes the pending batch and closes the store once the pipeline has drained
+func (u *TweetUploader) Close(ctx context.Context) error {
+	u.mu.Lock()
+	if !u.closed {
+		u.closed = true
+		close(u.queue)
+	}
+	u.mu.Unlock()
+	select {
+	case <-u.stopped:
+	case <-ctx.Done():
+		logger(ctx).Warn().Err(ctx.Err()).Msg("Timed out flushing pending uploads")
+	}
+	return u.store.Close(ctx)
+}
+
+func (u *TweetUploader) run() {
+	defer close(u.stopped)
+	defer close(u.errors)
+	batch := make([]analysis.TweetWithScoreMessage, 0, u.batchSize)
+	// the flush timer only runs while the batch has tweets waiting
+	var (
+		timer   *time.Timer
+		timeout <-chan time.Time
+	)
+	flush := func() {
+		if timer != nil {
+			timer.Stop()
+			timer, timeout = nil, nil
+		}
+		u.flush(batch)
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case msg, ok := <-u.queue:
+			if !ok {
+				if len(batch) > 0 {
+					flush()
+				}
+				return
+			}
+			batch = append(batch, msg)
+			if len(batch) == 1 {
+				timer = time.NewTimer(u.flushInterval)
+				timeout = timer.C
+			}
+			if len(batch) >= u.batchSize {
+				flush()
+			}
+		case <-timeout:
+			flush()
+		}
+	}
+}
+
+func (u *TweetUploader) flush(batch []analysis.TweetWithScoreMessage) {
+	ctx, cancel := context.WithTimeout(context.Background(), uploadTimeout)
+	defer cancel()
+
+	errs := u.store.UpsertMany(ctx, batch)
+	for i, msg := range batch {
+		if errs[i] != nil {
+			u.errors <- fmt.Errorf("could not upsert tweet %d: %w", msg.BaseTweet.ID, errs[i])
+		} else if u.onStored != nil {
+			u.onStored(msg)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/dghubble/go-twitter/twitter"
+	"github.com/jmoussa/go-sentitweet/analysis"
+	"github.com/jmoussa/go-sentitweet/config"
+)
+
+func TestTweetUploaderBatchesIntoStore(t *testing.T) {
+	store := NewMemoryStore()
+	cfg := config.Config{General: map[string]string{"upload_batch_size": "3", "upload_flush_interval": "20ms"}}
+	var (
+		mu     sync.Mutex
+		stored []int64
+	)
+	uploader, err := NewTweetUploader(store, cfg, func(msg analysis.TweetWithScoreMessage) {
+		mu.Lock()
+		defer mu.Unlock()
+		stored = append(stored, msg.BaseTweet.ID)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 4 tweets: one full batch plus one flushed by the interval, Upload doesn't wait for either
+	for id := int64(1); id <= 4; id++ {
+		msg := analysis.TweetWithScoreMessage{
+			BaseTweet: &twitter.Tweet{ID: id},
+			Scores:    map[string]analysis.SentimentResult{"vader": {Compound: 0.5}},
+		}
+		if _, err := uploader.Upload(msg); err != nil {
+			t.Errorf("Upload(%d): %v", id, err)
+		}
+	}
+
+	if _, err := uploader.Upload("not a tweet"); err == nil {
+		t.Error("expected an error for a non scored message")
+	}
+	if err := uploader.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := uploader.Upload(analysis.TweetWithScoreMessage{BaseTweet: &twitter.Tweet{ID: 5}}); err != errUploaderClosed {
+		t.Errorf("expected errUploaderClosed after Close, got %v", err)
+	}
+	if _, open := <-uploader.Errors(); open {
+		t.Error("expected no errors and the channel closed after Close")
+	}
+
+	summary, _ := store.Aggregate(context.Background(), "vader")
+	if summary.Count != 4 {
+		t.Errorf("expected 4 stored tweets, got %d", summary.Count)
+	}
+	if len(stored) != 4 {
+		t.Errorf("expected onStored for the 4 tweets, got %v", stored)
+	}
+}
+
+// failingStore fails to write the odd tweet IDs
+type failingStore struct {
+	*MemoryStore
+}
+
+func (s failingStore) UpsertMany(ctx context.Context, msgs []analysis.TweetWithScoreMessage) []error {
+	errs := make([]error, len(msgs))
+	for i, msg := range msgs {
+		if msg.BaseTweet.ID%2 == 1 {
+			errs[i] = errors.New("write failed")
+		}
+	}
+	return errs
+}
+
+func TestTweetUploaderReportsErrors(t *testing.T) {
+	cfg := config.Config{General: map[string]string{"upload_batch_size": "2"}}
+	uploader, err := NewTweetUploader(failingStore{NewMemoryStore()}, cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id := int64(1); id <= 3; id++ {
+		if _, err := uploader.Upload(analysis.TweetWithScoreMessage{BaseTweet: &twitter.Tweet{ID: id}}); err != nil {
+			t.Fatalf("Upload(%d): %v", id, err)
+		}
+	}
+	// the last batch is flushed, and its errors reported, by Close
+	go uploader.Close(context.Background())
+	var failed int
+	for range uploader.Errors() {
+		failed++
+	}
+	if failed != 2 {
+		t.Errorf("expected tweets 1 and 3 to be reported, got %d errors", failed)
+	}
+}
@@ -0,0 +1,73 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoussa/go-sentitweet/analysis"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TweetWatcher is implemented by the stores that can follow the tweets other processes write (the pipeline),
+// so an API server running on its own can still serve live tweets
+type TweetWatcher interface {
+	// Watch calls fn with every tweet stored, or scored again, from now on until ctx is done or the watch fails
+	Watch(ctx context.Context, fn func(analysis.TweetWithScoreMessage)) error
+}
+
+// scoresUpdated matches the update events setting scores, the upserts of the pipeline. The other updates
+// (`tw db backfill-dates`, most migrations) rewrite stored tweets and aren't live ones.
+var scoresUpdated = bson.M{"$gt": bson.A{
+	bson.M{"$size": bson.M{"$filter": bson.M{
+		"input": bson.M{"$objectToArray": "$updateDescription.updatedFields"},
+		"cond": bson.M{"$or": bson.A{
+			bson.M{"$eq": bson.A{"$$this.k", "scores"}},
+			bson.M{"$eq": bson.A{bson.M{"$substrCP": bson.A{"$$this.k", 0, 7}}, "scores."}},
+		}},
+	}}},
+	0,
+}}
+
+// Watch tails a change stream of the tweets collection, which needs mongo to run as a replica set.
+// Only new tweets and the scores merged into tweets ingested since the watch started are live.
+func (m *MongoStore) Watch(ctx context.Context, fn func(analysis.TweetWithScoreMessage)) error {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"$or": bson.A{
+			bson.M{"operationType": bson.M{"$in": bson.A{"insert", "replace"}}},
+			bson.M{"operationType": "update", "$expr": scoresUpdated},
+		}}}},
+	}
+	started := time.Now()
+	// upserts merging scores are updates, look the whole document up
+	stream, err := m.collection.Watch(ctx, pipeline, options.ChangeStream().SetFullDocument(options.UpdateLookup))
+	if err != nil {
+		return fmt.Errorf("could not watch %s (change streams need a replica set): %w", m.collection.Name(), err)
+	}
+	defer stream.Close(context.Background())
+	for stream.Next(ctx) {
+		var event struct {
+			OperationType string                          `bson:"operationType"`
+			FullDocument  *analysis.TweetWithScoreMessage `bson:"fullDocument"`
+		}
+		if err := stream.Decode(&event); err != nil {
+			logger(ctx).Warn().Err(err).Msg("Could not decode a change event")
+			continue
+		}
+		// the document may be gone by the time it's looked up
+		if event.FullDocument == nil || event.FullDocument.BaseTweet == nil {
+			continue
+		}
+		// scores rewritten on older tweets (the typed_scores migration) aren't live either
+		if event.OperationType == "update" && event.FullDocument.IngestedAt.Before(started) {
+			continue
+		}
+		fn(*event.FullDocument)
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return stream.Err()
+}
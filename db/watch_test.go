@@ -0,0 +1,48 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jmoussa/go-sentitweet/analysis"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestWatchSkipsRewrites(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("events", func(mt *mtest.T) {
+		event := func(id int64, op string, ingestedAt time.Time) bson.D {
+			return bson.D{
+				{Key: "_id", Value: bson.D{{Key: "_data", Value: "token"}}},
+				{Key: "operationType", Value: op},
+				{Key: "fullDocument", Value: bson.D{
+					{Key: "basetweet", Value: bson.D{{Key: "id", Value: id}}},
+					{Key: "ingested_at", Value: ingestedAt},
+				}},
+			}
+		}
+		now := time.Now().Add(time.Minute)
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "db.tweets", mtest.FirstBatch,
+			event(1, "insert", now),
+			// scores rewritten on a tweet stored before the watch
+			event(2, "update", now.AddDate(0, -1, 0)),
+			// a score merged into a live tweet
+			event(3, "update", now),
+		))
+
+		store := &MongoStore{collection: mt.Coll}
+		var ids []int64
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		store.Watch(ctx, func(msg analysis.TweetWithScoreMessage) {
+			ids = append(ids, msg.BaseTweet.ID)
+		})
+		if len(ids) != 2 || ids[0] != 1 || ids[1] != 3 {
+			t.Errorf("expected tweets 1 and 3 only, got %v", ids)
+		}
+	})
+}
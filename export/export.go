@@ -0,0 +1,220 @@
+package export
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/jmoussa/go-sentitweet/analysis"
+	"github.com/jmoussa/go-sentitweet/db"
+)
+
+/*
+Tweet exports (`tw query`, the pipeline's file sink): scored tweets written one at a time as CSV, JSON, NDJSON or Parquet,
+so a result of any size streams through without being held in memory (Parquet buffers up to a row group).
+Scored texts (`tw score`) are written the same way, see ScoreWriter.
+*/
+
+const (
+	FormatCSV    = "csv"
+	FormatJSON   = "json"
+	FormatNDJSON = "ndjson"
+)
+
+// Writer writes scored tweets in one of the export formats
+type Writer interface {
+	Write(msg analysis.TweetWithScoreMessage) error
+	// Close ends the output (closing JSON array, buffered CSV rows), it doesn't close the underlying io.Writer
+	Close() error
+}
+
+// NewWriter returns the Writer of format (FormatCSV, FormatJSON, FormatNDJSON or FormatParquet) writing to w
+func NewWriter(w io.Writer, format string) (Writer, error) {
+	switch format {
+	case FormatCSV:
+		return &csvWriter{w: csv.NewWriter(w), scorers: analysis.ScorerNames()}, nil
+	case FormatJSON:
+		return &jsonWriter{array: NewJSONArray(w)}, nil
+	case FormatNDJSON:
+		return &ndjsonWriter{enc: json.NewEncoder(w)}, nil
+	case FormatParquet:
+		return newParquetWriter(w)
+	default:
+		return nil, fmt.Errorf("unknown format %q (expected %s, %s, %s or %s)", format, FormatCSV, FormatJSON, FormatNDJSON, FormatParquet)
+	}
+}
+
+// PageFunc fetches the page of tweets starting at cursor, empty for the first page
+type PageFunc func(ctx context.Context, cursor string) (db.TweetPage, error)
+
+// Copy writes every tweet of the pages fetch returns, following the cursors, until the last page
+// or limit tweets (no limit when 0), and returns how many were written
+func Copy(ctx context.Context, w Writer, fetch PageFunc, limit int) (int, error) {
+	written := 0
+	cursor := ""
+	for {
+		page, err := fetch(ctx, cursor)
+		if err != nil {
+			return written, err
+		}
+		for _, msg := range page.Tweets {
+			if limit > 0 && written == limit {
+				return written, nil
+			}
+			if err := w.Write(msg); err != nil {
+				return written, err
+			}
+			written++
+		}
+		if page.NextCursor == "" || (limit > 0 && written == limit) {
+			return written, nil
+		}
+		cursor = page.NextCursor
+	}
+}
+
+// tweetColumns come first in the CSV export, then the score components of each scorer
+var tweetColumns = []string{"id", "created_at", "user", "text", "term"}
+
+// CSVHeader is the header row of the CSV export: the tweet columns then the components of each registered scorer
+func CSVHeader(scorers []string) []string {
+	header := append([]string{}, tweetColumns...)
+	for _, scorer := range scorers {
+		for _, component := range []string{"positive", "negative", "neutral", "compound", "label", "confidence"} {
+			header = append(header, scorer+"_"+component)
+		}
+	}
+	return header
+}
+
+// CSVRecord flattens msg into the columns of CSVHeader, the scores msg doesn't have are left empty
+func CSVRecord(msg analysis.TweetWithScoreMessage, scorers []string) []string {
+	record := make([]string, 0, len(tweetColumns)+6*len(scorers))
+	tweet := msg.BaseTweet
+	createdAt := ""
+	if !msg.CreatedAt.IsZero() {
+		createdAt = msg.CreatedAt.UTC().Format(time.RFC3339)
+	} else if tweet != nil {
+		createdAt = tweet.CreatedAt
+	}
+	var id, user, text string
+	if tweet != nil {
+		id, text = strconv.FormatInt(tweet.ID, 10), tweet.Text
+		if tweet.User != nil {
+			user = tweet.User.ScreenName
+		}
+	}
+	record = append(record, id, createdAt, user, text, msg.Term)
+	for _, scorer := range scorers {
+		score, ok := msg.Scores[scorer]
+		if !ok {
+			record = append(record, "", "", "", "", "", "")
+			continue
+		}
+		record = append(record,
+			formatFloat(score.Positive),
+			formatFloat(score.Negative),
+			formatFloat(score.Neutral),
+			formatFloat(score.Compound),
+			score.Label,
+			formatFloat(score.Confidence),
+		)
+	}
+	return record
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+type csvWriter struct {
+	w       *csv.Writer
+	scorers []string
+	header  bool
+}
+
+func (c *csvWriter) Write(msg analysis.TweetWithScoreMessage) error {
+	if !c.header {
+		c.header = true
+		if err := c.w.Write(CSVHeader(c.scorers)); err != nil {
+			return err
+		}
+	}
+	return c.w.Write(CSVRecord(msg, c.scorers))
+}
+
+func (c *csvWriter) Close() error {
+	// an empty export still gets its header
+	if !c.header {
+		c.header = true
+		if err := c.w.Write(CSVHeader(c.scorers)); err != nil {
+			return err
+		}
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// JSONArray streams values as the elements of a single JSON array, so the array never has to be held in memory
+type JSONArray struct {
+	w     io.Writer
+	count int
+}
+
+func NewJSONArray(w io.Writer) *JSONArray {
+	return &JSONArray{w: w}
+}
+
+func (a *JSONArray) Write(v interface{}) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	sep := ",\n"
+	if a.count == 0 {
+		sep = "[\n"
+	}
+	a.count++
+	_, err = fmt.Fprintf(a.w, "%s%s", sep, raw)
+	return err
+}
+
+// Close ends the array, an empty one when nothing was written
+func (a *JSONArray) Close() error {
+	if a.count == 0 {
+		_, err := io.WriteString(a.w, "[]\n")
+		return err
+	}
+	_, err := io.WriteString(a.w, "\n]\n")
+	return err
+}
+
+// jsonWriter streams a single JSON array of tweets
+type jsonWriter struct {
+	array *JSONArray
+}
+
+func (j *jsonWriter) Write(msg analysis.TweetWithScoreMessage) error {
+	return j.array.Write(msg)
+}
+
+func (j *jsonWriter) Close() error {
+	return j.array.Close()
+}
+
+// ndjsonWriter writes one JSON document per line
+type ndjsonWriter struct {
+	enc *json.Encoder
+}
+
+func (n *ndjsonWriter) Write(msg analysis.TweetWithScoreMessage) error {
+	return n.enc.Encode(msg)
+}
+
+func (n *ndjsonWriter) Close() error {
+	return nil
+}
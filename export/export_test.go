@@ -0,0 +1,134 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dghubble/go-twitter/twitter"
+	"github.com/jmoussa/go-sentitweet/analysis"
+	"github.com/jmoussa/go-sentitweet/db"
+)
+
+func testMessages(t *testing.T, n int) []analysis.TweetWithScoreMessage {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	msgs := make([]analysis.TweetWithScoreMessage, n)
+	for i := range msgs {
+		tweet := &twitter.Tweet{
+			ID:        int64(i + 1),
+			Text:      "I love this, \"really\"\nnew line",
+			CreatedAt: now.Add(-time.Duration(i) * time.Minute).Format(time.RubyDate),
+			User:      &twitter.User{ScreenName: "gopher"},
+		}
+		msg, err := analysis.ScoreTweet(analysis.VaderScorer{}, tweet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg.Term = "#golang"
+		msgs[i] = msg
+	}
+	return msgs
+}
+
+func writeAll(t *testing.T, format string, msgs []analysis.TweetWithScoreMessage) string {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range msgs {
+		if err := w.Write(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestCSV(t *testing.T) {
+	msgs := testMessages(t, 2)
+	records, err := csv.NewReader(strings.NewReader(writeAll(t, FormatCSV, msgs))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := CSVHeader(analysis.ScorerNames())
+	if len(records) != 3 || strings.Join(records[0], ",") != strings.Join(header, ",") {
+		t.Fatalf("unexpected records %v", records)
+	}
+	column := map[string]int{}
+	for i, name := range header {
+		column[name] = i
+	}
+	row := records[1]
+	if row[column["id"]] != "1" || row[column["user"]] != "gopher" || row[column["text"]] != msgs[0].BaseTweet.Text ||
+		row[column["term"]] != "#golang" || row[column["vader_label"]] != msgs[0].Scores["vader"].Label {
+		t.Fatalf("unexpected row %v", row)
+	}
+	// the tweets weren't scored by imdb
+	if row[column["imdb_compound"]] != "" {
+		t.Fatalf("expected an empty imdb score, got %v", row)
+	}
+
+	if got := writeAll(t, FormatCSV, nil); got != strings.Join(header, ",")+"\n" {
+		t.Fatalf("expected just the header, got %q", got)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	msgs := testMessages(t, 3)
+	for _, n := range []int{0, 1, 3} {
+		var decoded []analysis.TweetWithScoreMessage
+		if err := json.Unmarshal([]byte(writeAll(t, FormatJSON, msgs[:n])), &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if len(decoded) != n {
+			t.Fatalf("expected %d tweets, got %d", n, len(decoded))
+		}
+	}
+
+	lines := strings.Split(strings.TrimSpace(writeAll(t, FormatNDJSON, msgs)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 lines, got %q", lines)
+	}
+	var decoded analysis.TweetWithScoreMessage
+	if err := json.Unmarshal([]byte(lines[2]), &decoded); err != nil || decoded.BaseTweet.ID != 3 {
+		t.Fatalf("unexpected line %q: %v", lines[2], err)
+	}
+
+	if _, err := NewWriter(&bytes.Buffer{}, "xml"); err == nil {
+		t.Fatal("expected an error for an unknown format")
+	}
+}
+
+func TestCopy(t *testing.T) {
+	ctx := context.Background()
+	store := db.NewMemoryStore()
+	for _, err := range store.UpsertMany(ctx, testMessages(t, 5)) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	fetches := 0
+	fetch := func(ctx context.Context, cursor string) (db.TweetPage, error) {
+		fetches++
+		return store.Find(ctx, db.TweetQuery{Limit: 2, Cursor: cursor})
+	}
+	for _, tc := range []struct{ limit, written, fetches int }{{0, 5, 3}, {3, 3, 2}, {4, 4, 2}, {10, 5, 3}} {
+		fetches = 0
+		var buf bytes.Buffer
+		w, _ := NewWriter(&buf, FormatNDJSON)
+		written, err := Copy(ctx, w, fetch, tc.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if written != tc.written || fetches != tc.fetches || strings.Count(buf.String(), "\n") != tc.written {
+			t.Fatalf("limit %d: wrote %d tweets in %d fetches, expected %d in %d", tc.limit, written, fetches, tc.written, tc.fetches)
+		}
+	}
+}
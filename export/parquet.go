@@ -0,0 +1,64 @@
+package export
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/jmoussa/go-sentitweet/analysis"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// FormatParquet has the columns of the CSV export, typed (INT64 id, DOUBLE score components), missing scores are null
+const FormatParquet = "parquet"
+
+// parquetRowGroupSize bounds how much a parquet export buffers before writing a row group
+const parquetRowGroupSize = 16 * 1024 * 1024
+
+type parquetWriter struct {
+	pw      *writer.CSVWriter
+	scorers []string
+}
+
+func newParquetWriter(w io.Writer) (*parquetWriter, error) {
+	scorers := analysis.ScorerNames()
+	pw, err := writer.NewCSVWriterFromWriter(parquetSchema(CSVHeader(scorers)), w, 1)
+	if err != nil {
+		return nil, fmt.Errorf("could not start the parquet export: %w", err)
+	}
+	pw.RowGroupSize = parquetRowGroupSize
+	return &parquetWriter{pw: pw, scorers: scorers}, nil
+}
+
+// parquetSchema describes the CSV columns as parquet-go metadata
+func parquetSchema(header []string) []string {
+	schema := make([]string, len(header))
+	for i, column := range header {
+		kind := "type=BYTE_ARRAY, convertedtype=UTF8"
+		switch {
+		case column == "id":
+			kind = "type=INT64"
+		case i >= len(tweetColumns) && !strings.HasSuffix(column, "_label"):
+			// score components
+			kind = "type=DOUBLE"
+		}
+		schema[i] = fmt.Sprintf("name=%s, %s, repetitiontype=OPTIONAL", column, kind)
+	}
+	return schema
+}
+
+func (p *parquetWriter) Write(msg analysis.TweetWithScoreMessage) error {
+	record := CSVRecord(msg, p.scorers)
+	values := make([]*string, len(record))
+	for i := range record {
+		// the tweet columns are kept even when empty, only the scores the tweet doesn't have are null
+		if record[i] != "" || (i > 0 && i < len(tweetColumns)) {
+			values[i] = &record[i]
+		}
+	}
+	return p.pw.WriteString(values)
+}
+
+func (p *parquetWriter) Close() error {
+	return p.pw.WriteStop()
+}
@@ -0,0 +1,31 @@
+package export
+
+import (
+	"testing"
+
+	"github.com/jmoussa/go-sentitweet/analysis"
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+func TestParquet(t *testing.T) {
+	msgs := testMessages(t, 3)
+	out := writeAll(t, FormatParquet, msgs)
+
+	pf, err := buffer.NewBufferFile([]byte(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr, err := reader.NewParquetReader(pf, nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.ReadStop()
+	if rows := pr.GetNumRows(); rows != 3 {
+		t.Fatalf("expected 3 rows, got %d", rows)
+	}
+	// root + one leaf per CSV column
+	if columns := len(pr.SchemaHandler.SchemaElements) - 1; columns != len(CSVHeader(analysis.ScorerNames())) {
+		t.Fatalf("unexpected column count %d", columns)
+	}
+}
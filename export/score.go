@@ -0,0 +1,127 @@
+package export
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/jmoussa/go-sentitweet/analysis"
+)
+
+// FormatTable aligns the scored texts in columns, for a terminal
+const FormatTable = "table"
+
+// maxTableText is the number of characters of a text shown in a table row
+const maxTableText = 60
+
+// ScoreWriter writes one row per scored text (`tw score`)
+type ScoreWriter interface {
+	Write(text string, scores map[string]analysis.SentimentResult) error
+	// Flush writes out the buffered rows and ends the output (closing JSON array), it doesn't close the underlying io.Writer
+	Flush() error
+}
+
+// NewScoreWriter returns the ScoreWriter of format (FormatJSON, FormatCSV or FormatTable) writing the scores of scorers to w
+func NewScoreWriter(w io.Writer, format string, scorers []analysis.Scorer) (ScoreWriter, error) {
+	switch format {
+	case FormatJSON:
+		return &jsonScoreWriter{array: NewJSONArray(w)}, nil
+	case FormatCSV:
+		return &csvScoreWriter{w: csv.NewWriter(w), scorers: scorers}, nil
+	case FormatTable:
+		return &tableScoreWriter{w: tabwriter.NewWriter(w, 0, 4, 2, ' ', 0), scorers: scorers}, nil
+	default:
+		return nil, fmt.Errorf("unknown format %q (expected %s, %s or %s)", format, FormatJSON, FormatCSV, FormatTable)
+	}
+}
+
+// ScoreColumns are the header of the CSV and table rows: the text then the components of each scorer
+func ScoreColumns(scorers []analysis.Scorer) []string {
+	columns := []string{"text"}
+	for _, scorer := range scorers {
+		columns = append(columns, scorer.Name()+"_compound", scorer.Name()+"_label", scorer.Name()+"_confidence")
+	}
+	return columns
+}
+
+func scoreRow(text string, scores map[string]analysis.SentimentResult, scorers []analysis.Scorer) []string {
+	row := []string{text}
+	for _, scorer := range scorers {
+		result := scores[scorer.Name()]
+		row = append(row,
+			strconv.FormatFloat(result.Compound, 'f', 4, 64),
+			result.Label,
+			strconv.FormatFloat(result.Confidence, 'f', 4, 64),
+		)
+	}
+	return row
+}
+
+// jsonScoreWriter streams a JSON array of {"text", "scores"} objects, like POST /score returns
+type jsonScoreWriter struct {
+	array *JSONArray
+}
+
+func (j *jsonScoreWriter) Write(text string, scores map[string]analysis.SentimentResult) error {
+	return j.array.Write(struct {
+		Text   string                              `json:"text"`
+		Scores map[string]analysis.SentimentResult `json:"scores"`
+	}{text, scores})
+}
+
+func (j *jsonScoreWriter) Flush() error {
+	return j.array.Close()
+}
+
+type csvScoreWriter struct {
+	w       *csv.Writer
+	scorers []analysis.Scorer
+	header  bool
+}
+
+func (c *csvScoreWriter) Write(text string, scores map[string]analysis.SentimentResult) error {
+	if !c.header {
+		c.header = true
+		if err := c.w.Write(ScoreColumns(c.scorers)); err != nil {
+			return err
+		}
+	}
+	if err := c.w.Write(scoreRow(text, scores, c.scorers)); err != nil {
+		return err
+	}
+	// stream rows out as they are scored
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *csvScoreWriter) Flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// tableScoreWriter aligns the columns, so it only prints once every text is scored
+type tableScoreWriter struct {
+	w       *tabwriter.Writer
+	scorers []analysis.Scorer
+	header  bool
+}
+
+func (t *tableScoreWriter) Write(text string, scores map[string]analysis.SentimentResult) error {
+	if !t.header {
+		t.header = true
+		fmt.Fprintln(t.w, strings.ToUpper(strings.Join(ScoreColumns(t.scorers), "\t")))
+	}
+	text = strings.Join(strings.Fields(text), " ")
+	if runes := []rune(text); len(runes) > maxTableText {
+		text = string(runes[:maxTableText-3]) + "..."
+	}
+	_, err := fmt.Fprintln(t.w, strings.Join(scoreRow(text, scores, t.scorers), "\t"))
+	return err
+}
+
+func (t *tableScoreWriter) Flush() error {
+	return t.w.Flush()
+}
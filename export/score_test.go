@@ -0,0 +1,79 @@
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/jmoussa/go-sentitweet/analysis"
+)
+
+func writeScores(t *testing.T, format string, texts ...string) string {
+	scorers := []analysis.Scorer{analysis.VaderScorer{}}
+	var buf bytes.Buffer
+	w, err := NewScoreWriter(&buf, format, scorers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, text := range texts {
+		result, err := analysis.VaderScorer{}.Score(text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Write(text, map[string]analysis.SentimentResult{"vader": result}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestScoreCSV(t *testing.T) {
+	records, err := csv.NewReader(strings.NewReader(writeScores(t, FormatCSV, "I love this", "I hate this, \"really\""))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || strings.Join(records[0], ",") != "text,vader_compound,vader_label,vader_confidence" {
+		t.Fatalf("expected a header and 2 rows, got %v", records)
+	}
+	if records[1][2] != analysis.LabelPositive || records[2][0] != "I hate this, \"really\"" || records[2][2] != analysis.LabelNegative {
+		t.Errorf("unexpected rows %v", records[1:])
+	}
+}
+
+func TestScoreTable(t *testing.T) {
+	long := strings.Repeat("good ", 20)
+	lines := strings.Split(strings.TrimSpace(writeScores(t, FormatTable, "I love\nthis", long)), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "TEXT") {
+		t.Fatalf("expected a header and 2 rows, got %q", lines)
+	}
+	if !strings.HasPrefix(lines[1], "I love this ") {
+		t.Errorf("expected the text on one line, got %q", lines[1])
+	}
+	if !strings.Contains(lines[2], "...") || strings.Contains(lines[2], long) {
+		t.Errorf("expected the long text to be truncated, got %q", lines[2])
+	}
+}
+
+func TestScoreJSON(t *testing.T) {
+	var rows []struct {
+		Text   string                              `json:"text"`
+		Scores map[string]analysis.SentimentResult `json:"scores"`
+	}
+	if err := json.Unmarshal([]byte(writeScores(t, FormatJSON, "I love this", "meh")), &rows); err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0].Text != "I love this" || rows[0].Scores["vader"].Label != analysis.LabelPositive {
+		t.Errorf("unexpected rows %+v", rows)
+	}
+	if got := writeScores(t, FormatJSON); got != "[]\n" {
+		t.Errorf("expected an empty array, got %q", got)
+	}
+	if _, err := NewScoreWriter(&bytes.Buffer{}, "xml", nil); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
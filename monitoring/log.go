@@ -0,0 +1,144 @@
+package monitoring
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoussa/go-sentitweet/config"
+	"github.com/rs/zerolog"
+)
+
+/*
+Structured application logs.
+Each package logs through For(<package>) (or Ctx, which adds the request ID of an API request) so entries carry a
+"package" field and fields such as tweet_id, stage, term or latency instead of formatted strings.
+The config picks the output with log_format (console, the default, or json), the level with log_level (default info)
+and per package levels with log_levels, e.g. "db=debug,api=warn".
+*/
+
+// Field names shared by the packages, so one tweet or request can be followed across them
+const (
+	FieldPackage   = "package"
+	FieldRequestID = "request_id"
+	FieldTweetID   = "tweet_id"
+	FieldStage     = "stage"
+	FieldTerm      = "term"
+	FieldScorer    = "scorer"
+	FieldLatency   = "latency"
+)
+
+var (
+	loggersMu     sync.RWMutex
+	baseLogger    = newBaseLogger(os.Stderr, "console")
+	defaultLevel  = zerolog.InfoLevel
+	packageLevels = map[string]zerolog.Level{}
+	loggers       = map[string]*zerolog.Logger{}
+)
+
+func init() {
+	zerolog.DurationFieldUnit = time.Millisecond
+}
+
+func newBaseLogger(w io.Writer, format string) zerolog.Logger {
+	if format == "console" {
+		w = zerolog.ConsoleWriter{Out: w, TimeFormat: time.RFC3339}
+	}
+	return zerolog.New(w).With().Timestamp().Logger()
+}
+
+// ConfigureLogging applies log_format, log_level and log_levels to the package loggers
+func ConfigureLogging(cfg config.Config) error {
+	return configureLogging(os.Stderr, cfg)
+}
+
+func configureLogging(w io.Writer, cfg config.Config) error {
+	format := strings.ToLower(cfg.General["log_format"])
+	switch format {
+	case "":
+		format = "console"
+	case "console", "json":
+	default:
+		return fmt.Errorf("unknown log_format %q (expected console or json)", format)
+	}
+	level := LevelInfo
+	if raw := cfg.General["log_level"]; raw != "" {
+		var err error
+		if level, err = ParseLevel(raw); err != nil {
+			return err
+		}
+	}
+	levels := map[string]zerolog.Level{}
+	for _, override := range strings.Split(cfg.General["log_levels"], ",") {
+		if strings.TrimSpace(override) == "" {
+			continue
+		}
+		pkg, raw, ok := strings.Cut(override, "=")
+		if !ok {
+			return fmt.Errorf("invalid log_levels entry %q, expected <package>=<level>", override)
+		}
+		pkgLevel, err := ParseLevel(raw)
+		if err != nil {
+			return err
+		}
+		levels[strings.TrimSpace(pkg)] = pkgLevel.zerolog()
+	}
+
+	loggersMu.Lock()
+	defer loggersMu.Unlock()
+	baseLogger = newBaseLogger(w, format)
+	defaultLevel = level.zerolog()
+	packageLevels = levels
+	loggers = map[string]*zerolog.Logger{}
+	return nil
+}
+
+// For returns the logger of a package, at its configured level
+func For(pkg string) *zerolog.Logger {
+	loggersMu.RLock()
+	logger, ok := loggers[pkg]
+	loggersMu.RUnlock()
+	if ok {
+		return logger
+	}
+	loggersMu.Lock()
+	defer loggersMu.Unlock()
+	if logger, ok := loggers[pkg]; ok {
+		return logger
+	}
+	level, ok := packageLevels[pkg]
+	if !ok {
+		level = defaultLevel
+	}
+	l := baseLogger.Level(level).With().Str(FieldPackage, pkg).Logger()
+	loggers[pkg] = &l
+	return &l
+}
+
+type requestIDKey struct{}
+
+// WithRequestID tags ctx with the ID of the API request it serves
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the ID of the API request ctx serves, if any
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Ctx returns the logger of a package, with the request ID of ctx when it serves an API request
+func Ctx(ctx context.Context, pkg string) *zerolog.Logger {
+	logger := For(pkg)
+	id := RequestID(ctx)
+	if id == "" {
+		return logger
+	}
+	l := logger.With().Str(FieldRequestID, id).Logger()
+	return &l
+}
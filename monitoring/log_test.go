@@ -0,0 +1,64 @@
+package monitoring
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/jmoussa/go-sentitweet/config"
+)
+
+func TestConfigureLogging(t *testing.T) {
+	var out bytes.Buffer
+	defer ConfigureLogging(config.Config{})
+	err := configureLogging(&out, config.Config{General: map[string]string{
+		"log_format": "json",
+		"log_level":  "warn",
+		"log_levels": "db=debug, api = error",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	For("analysis").Info().Msg("hidden")
+	For("analysis").Warn().Int64(FieldTweetID, 42).Msg("shown")
+	For("db").Debug().Msg("shown")
+	For("api").Warn().Msg("hidden")
+	Ctx(WithRequestID(context.Background(), "abc"), "api").Error().Msg("shown")
+
+	var entries []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("expected JSON lines, got %q", line)
+		}
+		if entry["message"] != "shown" {
+			t.Fatalf("unexpected entry %v", entry)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(entries))
+	}
+	if entries[0][FieldPackage] != "analysis" || entries[0][FieldTweetID] != float64(42) {
+		t.Fatalf("unexpected fields %v", entries[0])
+	}
+	if entries[2][FieldRequestID] != "abc" || entries[2][FieldPackage] != "api" {
+		t.Fatalf("expected the request ID, got %v", entries[2])
+	}
+
+	for _, general := range []map[string]string{
+		{"log_format": "xml"},
+		{"log_level": "chatty"},
+		{"log_levels": "db"},
+		{"log_levels": "db=chatty"},
+	} {
+		if err := configureLogging(&out, config.Config{General: general}); err == nil {
+			t.Errorf("expected %v to be rejected", general)
+		}
+	}
+	if RequestID(context.Background()) != "" {
+		t.Error("expected no request ID outside of a request")
+	}
+}
@@ -1,19 +1,69 @@
 package monitoring
 
 import (
-	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/sns"
 	"github.com/jmoussa/go-sentitweet/config"
+	"github.com/rs/zerolog"
+)
+
+/*
+Pipeline logging.
+A Logger hands its entries to a Sink (stdout, a rotating file or an SNS topic, picked by log_sink) from a background
+goroutine, in batches of up to log_batch_size entries or whatever arrived within log_flush_interval.
+Logging never blocks the caller: entries below log_sink_level are discarded right away, and entries arriving while
+log_buffer entries are already waiting are dropped (counted by Dropped).
+*/
+
+// Level of a log entry, from the least to the most severe
+type Level string
+
+const (
+	LevelDebug Level = "DEBUG"
+	LevelInfo  Level = "INFO"
+	LevelWarn  Level = "WARN"
+	LevelError Level = "ERROR"
+)
+
+var levels = map[Level]zerolog.Level{
+	LevelDebug: zerolog.DebugLevel,
+	LevelInfo:  zerolog.InfoLevel,
+	LevelWarn:  zerolog.WarnLevel,
+	LevelError: zerolog.ErrorLevel,
+}
+
+// ParseLevel validates a level name, case insensitive
+func ParseLevel(raw string) (Level, error) {
+	level := Level(strings.ToUpper(strings.TrimSpace(raw)))
+	if _, ok := levels[level]; !ok {
+		return "", fmt.Errorf("unknown log level %q (expected debug, info, warn or error)", raw)
+	}
+	return level, nil
+}
+
+func (l Level) zerolog() zerolog.Level {
+	if level, ok := levels[l]; ok {
+		return level
+	}
+	return zerolog.NoLevel
+}
+
+const (
+	defaultLogBuffer        = 1024
+	defaultLogBatchSize     = 10
+	defaultLogFlushInterval = time.Second
 )
 
 type Log struct {
 	Message   string `json:"log_message"`
-	Level     string `json:"level"`
+	Level     Level  `json:"level"`
 	Type      string `json:"source"`
+	Stage     string `json:"stage,omitempty"`
 	Timestamp string `json:"timestamp"`
 }
 
@@ -21,32 +71,196 @@ func GetTimestamp() string {
 	return fmt.Sprintf("%v", time.Now().UTC())
 }
 
-func SendLogMessageToSNS(msgPtr *Log) (sns.PublishOutput, error) {
-	// Initialize a session that the SDK will use to load
-	// credentials from the shared credentials file. (~/.aws/credentials).
-	sess := session.Must(session.NewSessionWithOptions(session.Options{
-		SharedConfigState: session.SharedConfigEnable,
-	}))
-	svc := sns.New(sess)
-	var cfg config.Config = config.ParseConfig()
-	topicArn := cfg.General["aws_topic_arn"]
-	msg, err := json.Marshal(msgPtr)
+// Sink delivers log entries somewhere, a Logger calls it from a single goroutine and reuses entries after Send returns
+type Sink interface {
+	Send(entries []Log) error
+	Close() error
+}
+
+type LoggerOptions struct {
+	// Level is the lowest level delivered, default INFO
+	Level Level
+	// Buffer is the number of entries that may wait for the sink before new ones are dropped
+	Buffer int
+	// BatchSize is the most entries handed to the sink at once
+	BatchSize int
+	// FlushInterval is how long an entry may wait for its batch to fill up
+	FlushInterval time.Duration
+}
+
+// Logger delivers entries to its sink asynchronously, a nil *Logger discards everything
+type Logger struct {
+	sink          Sink
+	level         zerolog.Level
+	batchSize     int
+	flushInterval time.Duration
+	dropped       int64
+
+	mu      sync.RWMutex
+	closed  bool
+	queue   chan Log
+	stopped chan struct{}
+}
+
+func NewLogger(sink Sink, opts LoggerOptions) (*Logger, error) {
+	if opts.Level == "" {
+		opts.Level = LevelInfo
+	}
+	level, err := ParseLevel(string(opts.Level))
 	if err != nil {
-		return sns.PublishOutput{}, err
-	}
-	msgStr := string(msg)
-	topic := cfg.General["aws_logging_topic"]
-	result, err := svc.Publish(&sns.PublishInput{
-		Message:        &msgStr,
-		MessageGroupId: &topic,
-		TopicArn:       &topicArn,
-	})
+		return nil, err
+	}
+	if opts.Buffer <= 0 {
+		opts.Buffer = defaultLogBuffer
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultLogBatchSize
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = defaultLogFlushInterval
+	}
+	l := &Logger{
+		sink:          sink,
+		level:         level.zerolog(),
+		batchSize:     opts.BatchSize,
+		flushInterval: opts.FlushInterval,
+		queue:         make(chan Log, opts.Buffer),
+		stopped:       make(chan struct{}),
+	}
+	go l.run()
+	return l, nil
+}
+
+// NewLoggerFromConfig builds the logger selected by the config:
+//   - log_sink: stdout (default), file, sns or none
+//   - log_sink_level: the lowest level sent to the sink (default info), separate from the application's log_level
+//   - log_buffer, log_batch_size, log_flush_interval: see LoggerOptions
+//   - log_file, log_file_max_size_mb, log_file_max_backups: the file sink
+//   - aws_topic_arn, aws_logging_topic: the SNS sink
+func NewLoggerFromConfig(cfg config.Config) (*Logger, error) {
+	opts := LoggerOptions{Level: Level(cfg.General["log_sink_level"])}
+	for name, value := range map[string]*int{"log_buffer": &opts.Buffer, "log_batch_size": &opts.BatchSize} {
+		if raw := cfg.General[name]; raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid %s %q", name, raw)
+			}
+			*value = n
+		}
+	}
+	if raw := cfg.General["log_flush_interval"]; raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid log_flush_interval %q", raw)
+		}
+		opts.FlushInterval = d
+	}
+	sink, err := sinkFromConfig(cfg)
+	if err != nil || sink == nil {
+		return nil, err
+	}
+	logger, err := NewLogger(sink, opts)
 	if err != nil {
-		fmt.Printf("*** Error publishing to SNS: %v\n", err)
-		fmt.Println(err.Error())
-		var emptyResult sns.PublishOutput
-		return emptyResult, err
+		sink.Close()
+		return nil, err
+	}
+	return logger, nil
+}
+
+// Enabled reports whether entries of level are delivered, to skip building the ones that aren't
+func (l *Logger) Enabled(level Level) bool {
+	if l == nil {
+		return false
+	}
+	rank, ok := levels[level]
+	return ok && rank >= l.level
+}
+
+// Log queues entry for the sink without waiting, stamping it when it has no timestamp
+func (l *Logger) Log(entry Log) {
+	if !l.Enabled(entry.Level) {
+		return
+	}
+	if entry.Timestamp == "" {
+		entry.Timestamp = GetTimestamp()
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.closed {
+		return
+	}
+	select {
+	case l.queue <- entry:
+	default:
+		atomic.AddInt64(&l.dropped, 1)
+	}
+}
+
+// Dropped is the number of entries lost because the sink couldn't keep up
+func (l *Logger) Dropped() int64 {
+	if l == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&l.dropped)
+}
+
+// Close delivers the queued entries and closes the sink, entries logged afterwards are discarded
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	close(l.queue)
+	l.mu.Unlock()
+	<-l.stopped
+	if dropped := l.Dropped(); dropped > 0 {
+		For("monitoring").Warn().Int64("dropped", dropped).Msg("The log sink couldn't keep up, entries were dropped")
+	}
+	return l.sink.Close()
+}
+
+func (l *Logger) run() {
+	defer close(l.stopped)
+	batch := make([]Log, 0, l.batchSize)
+	// the flush timer only runs while the batch has entries waiting
+	var (
+		timer   *time.Timer
+		timeout <-chan time.Time
+	)
+	flush := func() {
+		if timer != nil {
+			timer.Stop()
+			timer, timeout = nil, nil
+		}
+		if err := l.sink.Send(batch); err != nil {
+			For("monitoring").Error().Err(err).Int("entries", len(batch)).Msg("Could not deliver log entries")
+		}
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case entry, ok := <-l.queue:
+			if !ok {
+				if len(batch) > 0 {
+					flush()
+				}
+				return
+			}
+			batch = append(batch, entry)
+			if len(batch) == 1 {
+				timer = time.NewTimer(l.flushInterval)
+				timeout = timer.C
+			}
+			if len(batch) >= l.batchSize {
+				flush()
+			}
+		case <-timeout:
+			flush()
+		}
 	}
-	fmt.Println("Success, message id:", *result.MessageId)
-	return *result, nil
 }
@@ -0,0 +1,232 @@
+package monitoring
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+	"github.com/jmoussa/go-sentitweet/config"
+)
+
+// memorySink records the batches it gets, blocking in Send while gate is held
+type memorySink struct {
+	mu      sync.Mutex
+	batches [][]Log
+	gate    sync.Mutex
+	closed  bool
+}
+
+func (s *memorySink) Send(entries []Log) error {
+	s.gate.Lock()
+	defer s.gate.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches = append(s.batches, append([]Log(nil), entries...))
+	return nil
+}
+
+func (s *memorySink) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestLogger(t *testing.T) {
+	sink := &memorySink{}
+	logger, err := NewLogger(sink, LoggerOptions{Level: "info", BatchSize: 2, FlushInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logger.Enabled(LevelDebug) || !logger.Enabled(LevelWarn) {
+		t.Fatal("expected only info and above to be enabled")
+	}
+	for _, level := range []Level{LevelDebug, LevelInfo, LevelError, LevelWarn, "LOUD"} {
+		logger.Log(Log{Message: string(level), Level: level})
+	}
+	if err := logger.Close(); err != nil {
+		t.Fatal(err)
+	}
+	logger.Log(Log{Message: "too late", Level: LevelError})
+	logger.Close()
+
+	// a full batch goes out right away, the partial one on Close
+	if len(sink.batches) != 2 || len(sink.batches[0]) != 2 || len(sink.batches[1]) != 1 || !sink.closed {
+		t.Fatalf("unexpected batches %+v", sink.batches)
+	}
+	if entry := sink.batches[0][0]; entry.Message != string(LevelInfo) || entry.Timestamp == "" {
+		t.Fatalf("unexpected first entry %+v", entry)
+	}
+
+	var discard *Logger
+	discard.Log(Log{Level: LevelError})
+	if discard.Enabled(LevelError) || discard.Close() != nil {
+		t.Fatal("expected a nil logger to discard everything")
+	}
+	if _, err := NewLogger(sink, LoggerOptions{Level: "loud"}); err == nil {
+		t.Fatal("expected an unknown level to be rejected")
+	}
+}
+
+func TestLoggerNeverBlocks(t *testing.T) {
+	sink := &memorySink{}
+	sink.gate.Lock()
+	logger, err := NewLogger(sink, LoggerOptions{Buffer: 2, BatchSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			logger.Log(Log{Level: LevelInfo})
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("logging blocked on a stuck sink")
+	}
+	sink.gate.Unlock()
+	logger.Close()
+	var delivered int64
+	for _, batch := range sink.batches {
+		delivered += int64(len(batch))
+	}
+	if delivered+logger.Dropped() != 100 || logger.Dropped() == 0 {
+		t.Fatalf("expected the entries to be delivered or dropped, got %d delivered and %d dropped", delivered, logger.Dropped())
+	}
+}
+
+func readLines(t *testing.T, path string) []Log {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var entries []Log
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry Log
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestRotatingFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pipeline.log")
+	line, _ := json.Marshal(Log{Message: "0", Level: LevelInfo})
+	// room for two entries per file
+	sink, err := NewRotatingFileSink(path, int64(2*(len(line)+1)), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range []string{"0", "1", "2", "3", "4", "5", "6"} {
+		if err := sink.Send([]Log{{Message: msg, Level: LevelInfo}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// 0 and 1 were rotated out of the backups
+	for file, want := range map[string][]string{path: {"6"}, path + ".1": {"4", "5"}, path + ".2": {"2", "3"}} {
+		entries := readLines(t, file)
+		if len(entries) != len(want) {
+			t.Fatalf("%s: expected %v, got %+v", file, want, entries)
+		}
+		for i, entry := range entries {
+			if entry.Message != want[i] {
+				t.Fatalf("%s: expected %v, got %+v", file, want, entries)
+			}
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatal("expected at most 2 backups")
+	}
+}
+
+type fakeSNS struct {
+	snsiface.SNSAPI
+	inputs []*sns.PublishBatchInput
+}
+
+func (f *fakeSNS) PublishBatch(input *sns.PublishBatchInput) (*sns.PublishBatchOutput, error) {
+	f.inputs = append(f.inputs, input)
+	out := &sns.PublishBatchOutput{}
+	for _, entry := range input.PublishBatchRequestEntries {
+		if *entry.Message == `{"log_message":"fail","level":"ERROR","source":"","timestamp":""}` {
+			out.Failed = append(out.Failed, &sns.BatchResultErrorEntry{Id: entry.Id})
+		}
+	}
+	return out, nil
+}
+
+func TestSNSSink(t *testing.T) {
+	client := &fakeSNS{}
+	sink := NewSNSSink(client, "arn:aws:sns:us-east-1:0:logging.fifo", "logging")
+	entries := make([]Log, 23)
+	for i := range entries {
+		entries[i] = Log{Message: "ok", Level: LevelInfo}
+	}
+	if err := sink.Send(entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.inputs) != 3 || len(client.inputs[2].PublishBatchRequestEntries) != 3 {
+		t.Fatalf("expected batches of at most 10 messages, got %d calls", len(client.inputs))
+	}
+	if entry := client.inputs[0].PublishBatchRequestEntries[9]; *entry.Id != "9" || *entry.MessageGroupId != "logging" {
+		t.Fatalf("unexpected entry %s", entry)
+	}
+	if err := sink.Send([]Log{{Message: "fail", Level: LevelError}}); err == nil {
+		t.Fatal("expected the failed entry to be reported")
+	}
+}
+
+func TestLoggerFromConfig(t *testing.T) {
+	for _, general := range []map[string]string{
+		{"log_sink": "carrier-pigeon"},
+		{"log_sink_level": "chatty"},
+		{"log_buffer": "0"},
+		{"log_sink": "file", "log_file_max_size_mb": "0"},
+		{"log_sink": "sns"},
+	} {
+		if _, err := NewLoggerFromConfig(config.Config{General: general}); err == nil {
+			t.Errorf("expected %v to be rejected", general)
+		}
+	}
+	logger, err := NewLoggerFromConfig(config.Config{General: map[string]string{"log_sink": "none"}})
+	if err != nil || logger != nil {
+		t.Fatalf("expected no logger, got %v %v", logger, err)
+	}
+	// the application log level doesn't turn the sink's traces on
+	logger, err = NewLoggerFromConfig(config.Config{General: map[string]string{"log_sink": "stdout", "log_level": "debug"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logger.Enabled(LevelDebug) {
+		t.Error("expected log_level to leave the sink at info")
+	}
+	logger.Close()
+	path := filepath.Join(t.TempDir(), "pipeline.log")
+	logger, err = NewLoggerFromConfig(config.Config{General: map[string]string{"log_sink": "file", "log_file": path, "log_sink_level": "debug"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Log(Log{Message: "hello", Level: LevelDebug})
+	if err := logger.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if entries := readLines(t, path); len(entries) != 1 || entries[0].Message != "hello" {
+		t.Fatalf("unexpected log file %+v", entries)
+	}
+}
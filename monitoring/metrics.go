@@ -0,0 +1,111 @@
+package monitoring
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+/*
+Prometheus metrics of the pipeline, the storage and the API, served on /metrics of the debug ports
+(:6070 for the pipeline, :6060 for the API) next to statsviz.
+*/
+
+// SentimentWindow is the number of most recent tweets the mean compound gauge averages, per term and scorer
+const SentimentWindow = 100
+
+var (
+	// PipelineTweets counts the tweets of each term by outcome: received, scored (once per scorer), stored, written or failed
+	PipelineTweets = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sentitweet_pipeline_tweets_total",
+		Help: "Tweets handled by the pipeline, by term and outcome (received, scored, stored, written, failed).",
+	}, []string{"term", "outcome"})
+
+	StageDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sentitweet_stage_duration_seconds",
+		Help:    "Time a pipeline stage takes to process one message.",
+		Buckets: prometheus.ExponentialBuckets(0.0005, 4, 10),
+	}, []string{"stage"})
+
+	// StageQueueDepth is the number of messages a stage took from its input but has no free slot for yet
+	StageQueueDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sentitweet_stage_queue_depth",
+		Help: "Messages waiting for a free slot of a pipeline stage, including the buffered input.",
+	}, []string{"stage"})
+
+	// StageInFlight and StageConcurrency give the semaphore saturation of a stage: in flight / concurrency
+	StageInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sentitweet_stage_in_flight",
+		Help: "Messages a pipeline stage is processing.",
+	}, []string{"stage"})
+	StageConcurrency = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sentitweet_stage_concurrency",
+		Help: "Messages a pipeline stage may process at once.",
+	}, []string{"stage"})
+	StageSemaphoreWait = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sentitweet_stage_semaphore_wait_seconds",
+		Help:    "Time a message waits for a free slot of a pipeline stage.",
+		Buckets: prometheus.ExponentialBuckets(0.0001, 4, 10),
+	}, []string{"stage"})
+
+	// StorageDuration times the storage operations, outcome is ok or error
+	StorageDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sentitweet_storage_operation_duration_seconds",
+		Help:    "Latency of the storage backend operations, by backend, operation and outcome.",
+		Buckets: prometheus.ExponentialBuckets(0.0005, 4, 10),
+	}, []string{"backend", "op", "outcome"})
+
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sentitweet_http_requests_total",
+		Help: "API requests served, by route, method and status.",
+	}, []string{"route", "method", "status"})
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sentitweet_http_request_duration_seconds",
+		Help:    "Latency of the API requests, by route, method and status (streams last as long as their client).",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	meanCompound = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sentitweet_sentiment_mean_compound",
+		Help: "Mean compound score of the most recent scored tweets, by term and scorer.",
+	}, []string{"term", "scorer"})
+)
+
+// rollingMean is the mean of the last SentimentWindow values
+type rollingMean struct {
+	values []float64
+	next   int
+}
+
+func (r *rollingMean) add(value float64) float64 {
+	if len(r.values) < SentimentWindow {
+		r.values = append(r.values, value)
+	} else {
+		r.values[r.next] = value
+		r.next = (r.next + 1) % SentimentWindow
+	}
+	var sum float64
+	for _, v := range r.values {
+		sum += v
+	}
+	return sum / float64(len(r.values))
+}
+
+var (
+	sentimentMu    sync.Mutex
+	sentimentMeans = map[[2]string]*rollingMean{}
+)
+
+// ObserveSentiment adds a scored tweet to the rolling mean compound gauge of its term and scorer
+func ObserveSentiment(term, scorer string, compound float64) {
+	sentimentMu.Lock()
+	defer sentimentMu.Unlock()
+	key := [2]string{term, scorer}
+	mean, ok := sentimentMeans[key]
+	if !ok {
+		mean = &rollingMean{}
+		sentimentMeans[key] = mean
+	}
+	meanCompound.WithLabelValues(term, scorer).Set(mean.add(compound))
+}
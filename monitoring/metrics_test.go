@@ -0,0 +1,27 @@
+package monitoring
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestObserveSentiment(t *testing.T) {
+	ObserveSentiment("#golang", "vader", 1)
+	ObserveSentiment("#golang", "vader", 0)
+	ObserveSentiment("#rust", "vader", -1)
+	if mean := testutil.ToFloat64(meanCompound.WithLabelValues("#golang", "vader")); mean != 0.5 {
+		t.Fatalf("expected a mean of 0.5, got %v", mean)
+	}
+	if mean := testutil.ToFloat64(meanCompound.WithLabelValues("#rust", "vader")); mean != -1 {
+		t.Fatalf("expected each term to have its own mean, got %v", mean)
+	}
+
+	// only the last SentimentWindow tweets count
+	for i := 0; i < SentimentWindow; i++ {
+		ObserveSentiment("#golang", "vader", -0.5)
+	}
+	if mean := testutil.ToFloat64(meanCompound.WithLabelValues("#golang", "vader")); mean != -0.5 {
+		t.Fatalf("expected the older scores to leave the window, got %v", mean)
+	}
+}
@@ -0,0 +1,234 @@
+package monitoring
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+	"github.com/jmoussa/go-sentitweet/config"
+)
+
+const (
+	defaultLogFile           = "sentitweet.log"
+	defaultLogFileMaxSizeMB  = 10
+	defaultLogFileMaxBackups = 3
+	// PublishBatch takes at most 10 messages
+	snsMaxBatch = 10
+)
+
+func sinkFromConfig(cfg config.Config) (Sink, error) {
+	switch kind := strings.ToLower(cfg.General["log_sink"]); kind {
+	case "", "stdout":
+		return NewWriterSink(os.Stdout), nil
+	case "none":
+		return nil, nil
+	case "file":
+		path := cfg.General["log_file"]
+		if path == "" {
+			path = defaultLogFile
+		}
+		limits := map[string]int{"log_file_max_size_mb": defaultLogFileMaxSizeMB, "log_file_max_backups": defaultLogFileMaxBackups}
+		for name := range limits {
+			if raw := cfg.General[name]; raw != "" {
+				n, err := strconv.Atoi(raw)
+				if err != nil || n < 0 || (n == 0 && name == "log_file_max_size_mb") {
+					return nil, fmt.Errorf("invalid %s %q", name, raw)
+				}
+				limits[name] = n
+			}
+		}
+		return NewRotatingFileSink(path, int64(limits["log_file_max_size_mb"])<<20, limits["log_file_max_backups"])
+	case "sns":
+		topicArn := cfg.General["aws_topic_arn"]
+		if topicArn == "" {
+			return nil, errors.New("the sns log sink needs aws_topic_arn")
+		}
+		// one session for the whole run, credentials come from the shared credentials file (~/.aws/credentials)
+		sess, err := session.NewSessionWithOptions(session.Options{SharedConfigState: session.SharedConfigEnable})
+		if err != nil {
+			return nil, err
+		}
+		return NewSNSSink(sns.New(sess), topicArn, cfg.General["aws_logging_topic"]), nil
+	default:
+		return nil, fmt.Errorf("unknown log_sink %q (expected stdout, file, sns or none)", kind)
+	}
+}
+
+// encodeLines writes entries as JSON lines
+func encodeLines(w io.Writer, entries []Log) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, entry := range entries {
+		if err := enc.Encode(entry); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// WriterSink writes JSON lines to a writer it doesn't own, such as os.Stdout
+type WriterSink struct {
+	w io.Writer
+}
+
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{w: w}
+}
+
+func (s *WriterSink) Send(entries []Log) error {
+	return encodeLines(s.w, entries)
+}
+
+func (s *WriterSink) Close() error {
+	return nil
+}
+
+// RotatingFileSink appends JSON lines to a file. Once the file would grow past maxSize it is renamed to
+// <path>.1 (shifting older backups up to <path>.<maxBackups>, the oldest is removed) and a new one is started.
+type RotatingFileSink struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+func NewRotatingFileSink(path string, maxSize int64, maxBackups int) (*RotatingFileSink, error) {
+	s := &RotatingFileSink{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *RotatingFileSink) open() error {
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	s.file, s.size = file, info.Size()
+	return nil
+}
+
+func (s *RotatingFileSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	backup := func(n int) string {
+		return fmt.Sprintf("%s.%d", s.path, n)
+	}
+	if s.maxBackups == 0 {
+		if err := os.Remove(s.path); err != nil {
+			return err
+		}
+		return s.open()
+	}
+	if err := os.Remove(backup(s.maxBackups)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for n := s.maxBackups - 1; n >= 1; n-- {
+		if err := os.Rename(backup(n), backup(n+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if err := os.Rename(s.path, backup(1)); err != nil {
+		return err
+	}
+	return s.open()
+}
+
+func (s *RotatingFileSink) Send(entries []Log) error {
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		line = append(line, '\n')
+		if s.size > 0 && s.size+int64(len(line)) > s.maxSize {
+			if err := s.rotate(); err != nil {
+				return fmt.Errorf("rotating %s: %w", s.path, err)
+			}
+		}
+		n, err := s.file.Write(line)
+		s.size += int64(n)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *RotatingFileSink) Close() error {
+	return s.file.Close()
+}
+
+// SNSSink publishes every entry as a message of an SNS topic, up to 10 per PublishBatch call
+type SNSSink struct {
+	client   snsiface.SNSAPI
+	topicArn string
+	// groupID is the message group of FIFO topics, empty for standard ones
+	groupID string
+}
+
+func NewSNSSink(client snsiface.SNSAPI, topicArn, groupID string) *SNSSink {
+	return &SNSSink{client: client, topicArn: topicArn, groupID: groupID}
+}
+
+func (s *SNSSink) Send(entries []Log) error {
+	var failed int
+	var lastErr error
+	for start := 0; start < len(entries); start += snsMaxBatch {
+		end := start + snsMaxBatch
+		if end > len(entries) {
+			end = len(entries)
+		}
+		input := &sns.PublishBatchInput{TopicArn: &s.topicArn}
+		for i, entry := range entries[start:end] {
+			msg, err := json.Marshal(entry)
+			if err != nil {
+				return err
+			}
+			req := &sns.PublishBatchRequestEntry{Id: stringPtr(strconv.Itoa(i)), Message: stringPtr(string(msg))}
+			if s.groupID != "" {
+				req.MessageGroupId = &s.groupID
+			}
+			input.PublishBatchRequestEntries = append(input.PublishBatchRequestEntries, req)
+		}
+		out, err := s.client.PublishBatch(input)
+		if err != nil {
+			failed += end - start
+			lastErr = err
+			continue
+		}
+		if len(out.Failed) > 0 {
+			failed += len(out.Failed)
+			lastErr = errors.New(out.Failed[0].String())
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("publishing to SNS failed for %d of %d entries: %w", failed, len(entries), lastErr)
+	}
+	return nil
+}
+
+func (s *SNSSink) Close() error {
+	return nil
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
@@ -0,0 +1,101 @@
+package stream
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/jmoussa/go-sentitweet/analysis"
+)
+
+/*
+In-process pub/sub of scored tweets, from the pipeline (or a change stream tail) to the API's live endpoints.
+Publishing never blocks: a subscriber that falls a full buffer behind misses tweets (counted by Dropped)
+rather than slowing the pipeline down.
+*/
+
+// DefaultBuffer is the number of tweets a subscriber may fall behind before missing some
+const DefaultBuffer = 256
+
+type Hub struct {
+	mu     sync.RWMutex
+	subs   map[*Subscription]struct{}
+	closed bool
+}
+
+func NewHub() *Hub {
+	return &Hub{subs: map[*Subscription]struct{}{}}
+}
+
+type Subscription struct {
+	// C receives the published tweets, it is closed when the subscription or the hub is
+	C       <-chan analysis.TweetWithScoreMessage
+	c       chan analysis.TweetWithScoreMessage
+	hub     *Hub
+	dropped int64
+}
+
+// Subscribe starts receiving the tweets published from now on, buffer defaults to DefaultBuffer when <= 0
+func (h *Hub) Subscribe(buffer int) *Subscription {
+	if buffer <= 0 {
+		buffer = DefaultBuffer
+	}
+	c := make(chan analysis.TweetWithScoreMessage, buffer)
+	sub := &Subscription{C: c, c: c, hub: h}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		close(c)
+		return sub
+	}
+	h.subs[sub] = struct{}{}
+	return sub
+}
+
+// Publish hands msg to every subscriber with room for it
+func (h *Hub) Publish(msg analysis.TweetWithScoreMessage) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for sub := range h.subs {
+		select {
+		case sub.c <- msg:
+		default:
+			atomic.AddInt64(&sub.dropped, 1)
+		}
+	}
+}
+
+// Subscribers is the number of open subscriptions
+func (h *Hub) Subscribers() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.subs)
+}
+
+// Close ends every subscription, later subscriptions are closed right away
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return
+	}
+	h.closed = true
+	for sub := range h.subs {
+		close(sub.c)
+		delete(h.subs, sub)
+	}
+}
+
+// Close stops the subscription, safe to call more than once and after the hub is closed
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	if _, ok := s.hub.subs[s]; ok {
+		delete(s.hub.subs, s)
+		close(s.c)
+	}
+}
+
+// Dropped is the number of tweets the subscription missed because its buffer was full
+func (s *Subscription) Dropped() int64 {
+	return atomic.LoadInt64(&s.dropped)
+}
@@ -0,0 +1,49 @@
+package stream
+
+import (
+	"testing"
+
+	"github.com/dghubble/go-twitter/twitter"
+	"github.com/jmoussa/go-sentitweet/analysis"
+)
+
+func tweet(id int64) analysis.TweetWithScoreMessage {
+	return analysis.TweetWithScoreMessage{BaseTweet: &twitter.Tweet{ID: id}}
+}
+
+func TestHub(t *testing.T) {
+	hub := NewHub()
+	fast, slow := hub.Subscribe(10), hub.Subscribe(1)
+	if hub.Subscribers() != 2 {
+		t.Fatalf("expected 2 subscribers, got %d", hub.Subscribers())
+	}
+	for id := int64(1); id <= 3; id++ {
+		hub.Publish(tweet(id))
+	}
+	for id := int64(1); id <= 3; id++ {
+		if msg := <-fast.C; msg.BaseTweet.ID != id {
+			t.Fatalf("expected tweet %d, got %d", id, msg.BaseTweet.ID)
+		}
+	}
+	// the slow subscriber kept the first tweet and missed the others instead of blocking Publish
+	if msg := <-slow.C; msg.BaseTweet.ID != 1 || slow.Dropped() != 2 || fast.Dropped() != 0 {
+		t.Fatalf("unexpected slow subscriber state: tweet %d, %d dropped", msg.BaseTweet.ID, slow.Dropped())
+	}
+
+	slow.Close()
+	slow.Close()
+	if _, open := <-slow.C; open || hub.Subscribers() != 1 {
+		t.Fatal("expected the closed subscription to be removed")
+	}
+
+	hub.Close()
+	if _, open := <-fast.C; open {
+		t.Fatal("expected closing the hub to close its subscriptions")
+	}
+	fast.Close()
+	late := hub.Subscribe(0)
+	if _, open := <-late.C; open {
+		t.Fatal("expected a subscription to a closed hub to be closed")
+	}
+	hub.Publish(tweet(4))
+}